@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+
+	"github.com/MakeNowJust/heredoc"
+	"github.com/dependabot/cli/internal/infra"
+	"github.com/spf13/cobra"
+)
+
+var attachCmd = NewAttachCommand()
+
+func init() {
+	rootCmd.AddCommand(attachCmd)
+}
+
+func NewAttachCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "attach <run-id>",
+		Short: "Open a shell in the updater of a run kept with --keep-on-failure",
+		Example: heredoc.Doc(`
+			$ dependabot update go_modules dependabot/cli --keep-on-failure
+			$ dependabot attach eager_turing
+		`),
+		Args: func(cmd *cobra.Command, args []string) error {
+			return newUsageError(cobra.ExactArgs(1)(cmd, args))
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+
+			err := infra.Attach(context.Background(), args[0])
+			if errors.Is(err, infra.ErrUnknownRun) {
+				return newUsageError(err)
+			}
+			return err
+		},
+	}
+}
@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+
+	"github.com/dependabot/cli/internal/infra"
+	"github.com/spf13/cobra"
+)
+
+var cleanupCmd = NewCleanupCommand()
+
+func init() {
+	rootCmd.AddCommand(cleanupCmd)
+}
+
+func NewCleanupCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "cleanup <run-id>",
+		Short: "Remove the containers and networks of a run kept with --keep-on-failure",
+		Args: func(cmd *cobra.Command, args []string) error {
+			return newUsageError(cobra.ExactArgs(1)(cmd, args))
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+
+			err := infra.Cleanup(context.Background(), args[0])
+			if errors.Is(err, infra.ErrUnknownRun) {
+				return newUsageError(err)
+			}
+			return err
+		},
+	}
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+
+	"github.com/MakeNowJust/heredoc"
+	"github.com/dependabot/cli/internal/infra"
+	"github.com/spf13/cobra"
+)
+
+var credsCmd = NewCredsCommand()
+
+func init() {
+	rootCmd.AddCommand(credsCmd)
+}
+
+func NewCredsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "creds <subcommand>",
+		Short: "Work with the credentials of a job",
+	}
+	cmd.AddCommand(NewCredsCheckCommand())
+	return cmd
+}
+
+func NewCredsCheckCommand() *cobra.Command {
+	var flags SharedFlags
+
+	cmd := &cobra.Command{
+		Use:   "check -f <input.yml>",
+		Short: "Check each credential with a request to its registry through the proxy",
+		Long: heredoc.Doc(`
+			Check each credential with a request to its registry through the proxy,
+			which adds the credential like it does for an update.
+			Each credential is reported as OK, unauthorized, not found, or unreachable.
+			The credential values are never printed.
+		`),
+		Example: heredoc.Doc(`
+			$ dependabot creds check -f input.yml
+		`),
+		Args: func(cmd *cobra.Command, args []string) error {
+			return newUsageError(cobra.NoArgs(cmd, args))
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+
+			if flags.file == "" {
+				return newUsageError(errors.New("requires an input file"))
+			}
+			input, err := readInputFile(flags.file)
+			if err != nil {
+				return newUsageError(err)
+			}
+
+			checks, err := infra.CheckCredentials(context.Background(), infra.RunParams{
+				Creds:          input.Credentials,
+				Job:            &input.Job,
+				ProxyCertPaths: flags.proxyCertPaths,
+				ProxyImage:     proxyImage,
+				PullImages:     flags.pullImages,
+				ExtraHosts:     flags.extraHosts,
+			})
+			if err != nil {
+				return err
+			}
+			if err = infra.WriteCredentialChecks(cmd.OutOrStdout(), checks); err != nil {
+				return err
+			}
+			return infra.FailedCredentials(checks)
+		},
+	}
+
+	cmd.Flags().StringVarP(&flags.file, "file", "f", "", "path to input file")
+	cmd.Flags().StringArrayVar(&flags.proxyCertPaths, "proxy-cert", nil, "path to a certificate, bundle, or directory of certificates the proxy will trust")
+	cmd.Flags().BoolVar(&flags.pullImages, "pull", true, "pull the image if it isn't present")
+	cmd.Flags().StringArrayVar(&flags.extraHosts, "extra-hosts", nil, "Docker extra hosts setting on the proxy")
+
+	return cmd
+}
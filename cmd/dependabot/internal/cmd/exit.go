@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/dependabot/cli/internal/infra"
+)
+
+// Exit codes returned by the CLI, so wrappers can tell failures apart without reading the logs.
+const (
+	// ExitSuccess is returned when the command completed.
+	ExitSuccess = 0
+	// ExitFailure is returned for errors that don't fit in any other category.
+	ExitFailure = 1
+	// ExitUsage is returned for invalid flags, arguments, or input files.
+	ExitUsage = 2
+	// ExitInfrastructure is returned when Docker, the network, or pulling an image failed.
+	ExitInfrastructure = 3
+	// ExitTimeout is returned when the update took longer than --timeout.
+	ExitTimeout = 4
+	// ExitUpdaterCrash is returned when the updater exited with a non-zero code without reporting an error.
+	ExitUpdaterCrash = 5
+	// ExitJobError is returned when the updater reported an error such as dependency_file_not_found.
+	ExitJobError = 6
+	// ExitExec is returned when the command run with --exec or --exec-file exited with a non-zero code,
+	// which is in the message and the error file.
+	ExitExec = 7
+)
+
+// usageError marks an error as being caused by how the CLI was called.
+type usageError struct {
+	err error
+}
+
+func (e *usageError) Error() string {
+	return e.err.Error()
+}
+
+func (e *usageError) Unwrap() error {
+	return e.err
+}
+
+func newUsageError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &usageError{err: err}
+}
+
+// exitCode maps an error returned by a command to one of the exit codes above.
+func exitCode(err error) int {
+	var usageErr *usageError
+	var jobErr *infra.JobError
+	var exitErr *infra.UpdaterExitError
+	var execErr *infra.ExecExitError
+	switch {
+	case err == nil:
+		return ExitSuccess
+	case errors.As(err, &execErr):
+		return ExitExec
+	case errors.As(err, &usageErr):
+		return ExitUsage
+	case errors.Is(err, context.DeadlineExceeded):
+		return ExitTimeout
+	case errors.As(err, &jobErr):
+		return ExitJobError
+	case errors.As(err, &exitErr):
+		return ExitUpdaterCrash
+	case errors.Is(err, infra.ErrInfrastructure):
+		return ExitInfrastructure
+	default:
+		return ExitFailure
+	}
+}
+
+// ErrorFile is the machine-readable result written by --error-file.
+type ErrorFile struct {
+	ExitCode     int            `json:"exit-code"`
+	ExecExitCode int            `json:"exec-exit-code,omitempty"`
+	Message      string         `json:"message,omitempty"`
+	ErrorType    string         `json:"error-type,omitempty"`
+	ErrorDetails map[string]any `json:"error-details,omitempty"`
+}
+
+// writeErrorFile records the outcome of the run in a sidecar file.
+func writeErrorFile(path string, err error) error {
+	if path == "" {
+		return nil
+	}
+	result := ErrorFile{ExitCode: exitCode(err)}
+	if err != nil {
+		result.Message = err.Error()
+	}
+	var jobErr *infra.JobError
+	if errors.As(err, &jobErr) {
+		result.ErrorType = jobErr.ErrorType
+		result.ErrorDetails = jobErr.ErrorDetails
+	}
+	var execErr *infra.ExecExitError
+	if errors.As(err, &execErr) {
+		result.ExecExitCode = execErr.Code
+	}
+	data, marshalErr := json.MarshalIndent(result, "", "  ")
+	if marshalErr != nil {
+		return fmt.Errorf("failed to marshal error file: %w", marshalErr)
+	}
+	if writeErr := os.WriteFile(path, append(data, '\n'), 0600); writeErr != nil {
+		return fmt.Errorf("failed to write error file: %w", writeErr)
+	}
+	return nil
+}
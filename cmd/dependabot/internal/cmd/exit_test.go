@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dependabot/cli/internal/infra"
+)
+
+func Test_exitCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected int
+	}{
+		{"success", nil, ExitSuccess},
+		{"unclassified", errors.New("boom"), ExitFailure},
+		{"usage", newUsageError(errors.New("requires a package manager argument")), ExitUsage},
+		{"timeout", fmt.Errorf("waiting: %w", context.DeadlineExceeded), ExitTimeout},
+		{"updater crash", &infra.UpdaterExitError{Code: 2}, ExitUpdaterCrash},
+		{"job error", &infra.JobError{ErrorType: "dependency_file_not_found"}, ExitJobError},
+		{"infrastructure", fmt.Errorf("pulling: %w", infra.ErrInfrastructure), ExitInfrastructure},
+		{"exec", &infra.ExecExitError{Code: 42}, ExitExec},
+		{"exec with a CLI exit code", &infra.ExecExitError{Code: ExitUsage}, ExitExec},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := exitCode(tt.err); actual != tt.expected {
+				t.Errorf("expected exit code %d, got %d", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_writeErrorFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "error.json")
+	err := &infra.JobError{
+		ErrorType:    "dependency_file_not_found",
+		ErrorDetails: map[string]any{"file-path": "/go.mod"},
+	}
+	if writeErr := writeErrorFile(path, err); writeErr != nil {
+		t.Fatal(writeErr)
+	}
+
+	data, readErr := os.ReadFile(path)
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	var actual ErrorFile
+	if jsonErr := json.Unmarshal(data, &actual); jsonErr != nil {
+		t.Fatal(jsonErr)
+	}
+	if actual.ExitCode != ExitJobError {
+		t.Errorf("expected exit code %d, got %d", ExitJobError, actual.ExitCode)
+	}
+	if actual.ErrorType != "dependency_file_not_found" {
+		t.Errorf("expected error type to be recorded, got %q", actual.ErrorType)
+	}
+	if actual.ErrorDetails["file-path"] != "/go.mod" {
+		t.Errorf("expected error details to be recorded, got %v", actual.ErrorDetails)
+	}
+}
+
+func Test_writeErrorFile_exec(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "error.json")
+	if writeErr := writeErrorFile(path, &infra.ExecExitError{Code: 2}); writeErr != nil {
+		t.Fatal(writeErr)
+	}
+
+	data, readErr := os.ReadFile(path)
+	if readErr != nil {
+		t.Fatal(readErr)
+	}
+	var actual ErrorFile
+	if jsonErr := json.Unmarshal(data, &actual); jsonErr != nil {
+		t.Fatal(jsonErr)
+	}
+	if actual.ExitCode != ExitExec || actual.ExecExitCode != 2 || actual.Message != "exec exited with code 2" {
+		t.Errorf("expected the command's exit code to be recorded, got %+v", actual)
+	}
+}
@@ -16,7 +16,7 @@ type SharedFlags struct {
 	cache                       string
 	debugging                   bool
 	flamegraph                  bool
-	proxyCertPath               string
+	proxyCertPaths              []string
 	collectorConfigPath         string
 	extraHosts                  []string
 	output                      string
@@ -25,6 +25,34 @@ type SharedFlags struct {
 	timeout                     time.Duration
 	local                       string
 	updaterEnvironmentVariables []string
+	artifactsDir                string
+	otlpEndpoint                string
+	telemetryDir                string
+	keepOnFailure               bool
+	exec                        string
+	execFile                    string
+	corePath                    string
+	recordPath                  string
+	replayPath                  string
+	harPath                     string
+	harBodyLimit                int
+	allowedHosts                []string
+	restrictEgress              bool
+	preflight                   bool
+	allowedTokenPermissions     []string
+	caCertPath                  string
+	caKeyPath                   string
+	caDir                       string
+	caKeyType                   string
+	caValidity                  time.Duration
+	updaterCertPaths            []string
+	apiFaultsPath               string
+	strictAPI                   bool
+	recordMetrics               bool
+	forwardTo                   []string
+	forwardBatch                int
+	fullDiff                    bool
+	updateExpectations          bool
 }
 
 // root flags
@@ -33,24 +61,39 @@ var (
 	proxyImage     string
 	collectorImage string
 	storageImage   string
+	logFormat      string
 )
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "dependabot <command> <subcommand> [flags]",
 	Short: "Dependabot end-to-end runner",
-	Long:  `Run Dependabot jobs from the command line.`,
+	Long: heredoc.Doc(`
+		Run Dependabot jobs from the command line.
+
+		Exit codes:
+		  0  the command completed
+		  1  an unclassified failure
+		  2  invalid flags, arguments, or input
+		  3  an infrastructure failure, e.g. Docker, the network, or pulling an image
+		  4  the update timed out
+		  5  the updater crashed
+		  6  the updater reported an error with record_update_job_error
+	`),
 	Example: heredoc.Doc(`
         $ dependabot update go_modules dependabot/cli
         $ dependabot test -f input.yml
 	`),
 	Version: Version(),
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return newUsageError(infra.SetLogFormat(logFormat))
+	},
 }
 
 func Execute() {
 	err := rootCmd.Execute()
 	if err != nil {
-		os.Exit(1)
+		os.Exit(exitCode(err))
 	}
 }
 
@@ -58,8 +101,13 @@ func init() {
 	log.SetFlags(log.Ldate | log.Ltime | log.LUTC)
 	log.SetPrefix("    cli | ")
 
+	rootCmd.SetFlagErrorFunc(func(_ *cobra.Command, err error) error {
+		return newUsageError(err)
+	})
+
 	rootCmd.PersistentFlags().StringVar(&updaterImage, "updater-image", "", "container image to use for the updater")
 	rootCmd.PersistentFlags().StringVar(&proxyImage, "proxy-image", infra.ProxyImageName, "container image to use for the proxy")
 	rootCmd.PersistentFlags().StringVar(&collectorImage, "collector-image", infra.CollectorImageName, "container image to use for the OpenTelemetry collector")
 	rootCmd.PersistentFlags().StringVar(&storageImage, "storage-image", infra.StorageImageName, "container image to use for the storage service")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", infra.LogFormatText, "format of the logs written to stderr: text or json")
 }
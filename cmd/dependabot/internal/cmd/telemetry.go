@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/MakeNowJust/heredoc"
+	"github.com/dependabot/cli/internal/infra"
+	"github.com/spf13/cobra"
+)
+
+var telemetryCmd = NewTelemetryCommand()
+
+func init() {
+	rootCmd.AddCommand(telemetryCmd)
+}
+
+func NewTelemetryCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "telemetry <subcommand>",
+		Short: "Inspect telemetry captured with --telemetry-out",
+	}
+	cmd.AddCommand(NewTelemetryShowCommand())
+	return cmd
+}
+
+func NewTelemetryShowCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "show <dir>",
+		Short: "Print the spans captured in a telemetry directory as a tree",
+		Example: heredoc.Doc(`
+			$ dependabot update go_modules dependabot/cli --telemetry-out telemetry
+			$ dependabot telemetry show telemetry
+		`),
+		Args: func(cmd *cobra.Command, args []string) error {
+			return newUsageError(cobra.ExactArgs(1)(cmd, args))
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+
+			spans, err := readSpans(filepath.Join(args[0], infra.TelemetryTracesFile))
+			if errors.Is(err, os.ErrNotExist) {
+				return newUsageError(err)
+			} else if err != nil {
+				return err
+			}
+			// the CLI writes its own spans from the host, they're missing when it sent them to --otlp-endpoint
+			cliSpans, err := readSpans(filepath.Join(args[0], infra.TelemetryCLITracesFile))
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			spans = append(cliSpans, spans...)
+			if len(spans) == 0 {
+				_, err = fmt.Fprintln(cmd.OutOrStdout(), "No spans were recorded")
+				return err
+			}
+			return infra.WriteSpanTree(cmd.OutOrStdout(), spans)
+		},
+	}
+}
+
+func readSpans(path string) ([]infra.TelemetrySpan, error) {
+	f, err := os.Open(path) //nolint:gosec // the directory is provided by the user via CLI arguments
+	if err != nil {
+		return nil, fmt.Errorf("failed to open traces: %w", err)
+	}
+	defer f.Close()
+	return infra.ReadSpans(f)
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"slices"
 
 	"github.com/dependabot/cli/internal/infra"
 	"github.com/dependabot/cli/internal/model"
@@ -24,30 +25,35 @@ func NewTestCommand() *cobra.Command {
 		Short: "Run a smoke test",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if flags.file == "" {
-				return fmt.Errorf("requires a smoke test file to run, use -f <file>")
+				return newUsageError(fmt.Errorf("requires a smoke test file to run, use -f <file>"))
 			}
 
 			smokeTest, inputRaw, err := readSmokeTest(flags.file)
 			if err != nil {
-				return err
+				return newUsageError(err)
 			}
 
 			processInput(&smokeTest.Input, nil)
 
-			if err := executeTestJob(infra.RunParams{
+			params := infra.RunParams{
 				CacheDir:                    flags.cache,
 				CollectorConfigPath:         flags.collectorConfigPath,
 				CollectorImage:              collectorImage,
 				Creds:                       smokeTest.Input.Credentials,
 				Debug:                       flags.debugging,
 				Expected:                    smokeTest.Output,
+				ExpectedOrder:               smokeTest.Order,
+				ExpectedMode:                smokeTest.Mode,
+				FullDiff:                    flags.fullDiff,
+				UpdateExpectations:          flags.updateExpectations,
 				ExtraHosts:                  flags.extraHosts,
 				InputName:                   flags.file,
 				InputRaw:                    inputRaw,
 				Job:                         &smokeTest.Input.Job,
 				LocalDir:                    flags.local,
 				Output:                      flags.output,
-				ProxyCertPath:               flags.proxyCertPath,
+				ProxyCertPaths:              flags.proxyCertPaths,
+				UpdaterCertPaths:            flags.updaterCertPaths,
 				ProxyImage:                  proxyImage,
 				PullImages:                  flags.pullImages,
 				StorageImage:                storageImage,
@@ -55,8 +61,44 @@ func NewTestCommand() *cobra.Command {
 				UpdaterImage:                updaterImage,
 				Volumes:                     flags.volumes,
 				UpdaterEnvironmentVariables: flags.updaterEnvironmentVariables,
-			}); err != nil {
-				log.Fatal(err)
+				ArtifactsDir:                flags.artifactsDir,
+				OTLPEndpoint:                flags.otlpEndpoint,
+				TelemetryDir:                flags.telemetryDir,
+				KeepOnFailure:               flags.keepOnFailure,
+				Exec:                        flags.exec,
+				ExecFile:                    flags.execFile,
+				CorePath:                    flags.corePath,
+				RecordPath:                  flags.recordPath,
+				ReplayPath:                  flags.replayPath,
+				HARPath:                     flags.harPath,
+				HARBodyLimit:                flags.harBodyLimit,
+				AllowedHosts:                slices.Concat(smokeTest.Input.AllowedHosts, flags.allowedHosts),
+				RestrictEgress:              smokeTest.Input.RestrictEgress || flags.restrictEgress,
+				APIFaults:                   smokeTest.Input.APIFaults,
+				APIFaultsPath:               flags.apiFaultsPath,
+				StrictAPI:                   flags.strictAPI,
+				RecordMetrics:               flags.recordMetrics,
+				ForwardTo:                   flags.forwardTo,
+				ForwardSecret:               os.Getenv("DEPENDABOT_FORWARD_SECRET"),
+				ForwardBatch:                flags.forwardBatch,
+				Preflight:                   flags.preflight,
+				AllowedTokenPermissions:     flags.allowedTokenPermissions,
+				CACertPath:                  flags.caCertPath,
+				CAKeyPath:                   flags.caKeyPath,
+				CADir:                       flags.caDir,
+				CAKeyType:                   flags.caKeyType,
+				CAValidity:                  flags.caValidity,
+			}
+			if err := params.Validate(); err != nil {
+				return newUsageError(err)
+			}
+
+			cmd.SilenceUsage = true
+			cmd.SilenceErrors = true
+
+			if err := executeTestJob(params); err != nil {
+				log.Println(err)
+				return err
 			}
 
 			return nil
@@ -66,13 +108,41 @@ func NewTestCommand() *cobra.Command {
 	cmd.Flags().StringVarP(&flags.file, "file", "f", "", "path to the smoke test")
 
 	cmd.Flags().StringVarP(&flags.output, "output", "o", "", "write a smoke test to file")
+	cmd.Flags().BoolVar(&flags.updateExpectations, "update", false, "rewrite the output section of the smoke test with the actual outputs, keeping the rest of the file")
+	cmd.Flags().BoolVar(&flags.fullDiff, "full-diff", false, "show a diff of the whole smoke test when expectations fail, instead of the fields that differ")
 	cmd.Flags().StringVar(&flags.cache, "cache", "", "cache import/export directory")
 	cmd.Flags().StringVar(&flags.local, "local", "", "local directory to use as fetched source")
-	cmd.Flags().StringVar(&flags.proxyCertPath, "proxy-cert", "", "path to a certificate the proxy will trust")
-	cmd.Flags().StringVar(&flags.collectorConfigPath, "collector-config", "", "path to an OpenTelemetry collector config file")
+	cmd.Flags().StringArrayVar(&flags.proxyCertPaths, "proxy-cert", nil, "path to a certificate, bundle, or directory of certificates the proxy will trust")
+	cmd.Flags().StringArrayVar(&flags.updaterCertPaths, "updater-cert", nil, "path to a certificate, bundle, or directory of certificates the updater will trust")
+	cmd.Flags().StringVar(&flags.caCertPath, "ca-cert", "", "path to a CA certificate for the proxy to intercept HTTPS with, instead of generating one")
+	cmd.Flags().StringVar(&flags.caKeyPath, "ca-key", "", "path to the private key of the --ca-cert CA")
+	cmd.Flags().StringVar(&flags.caDir, "ca-dir", "", "directory to keep a CA in, it's generated on first use and reused by later runs")
+	cmd.Flags().StringVar(&flags.caKeyType, "ca-key-type", "rsa", "key type of a generated CA, rsa or ecdsa")
+	cmd.Flags().DurationVar(&flags.caValidity, "ca-validity", 0, "how long a generated CA is valid for, two years by default")
+	cmd.Flags().StringVar(&flags.collectorConfigPath, "collector-config", "", "path to an OpenTelemetry collector config file, requires --otlp-endpoint for the CLI's own traces")
+	cmd.Flags().StringVar(&flags.artifactsDir, "artifacts-dir", "", "directory to collect logs, inputs, outputs, and a run manifest in")
+	cmd.Flags().StringVar(&flags.otlpEndpoint, "otlp-endpoint", "", "OTLP/HTTP endpoint to send the CLI's traces to, e.g. http://localhost:4318")
+	cmd.Flags().StringVar(&flags.telemetryDir, "telemetry-out", "", "directory to write traces, metrics, and logs to as OTLP JSON, instead of using --collector-config")
+	cmd.Flags().BoolVar(&flags.keepOnFailure, "keep-on-failure", false, "leave the containers running if the update fails, to inspect them with the attach command")
 	cmd.Flags().BoolVar(&flags.pullImages, "pull", true, "pull the image if it isn't present")
 	cmd.Flags().BoolVar(&flags.debugging, "debug", false, "run an interactive shell inside the updater")
+	cmd.Flags().StringVar(&flags.exec, "exec", "", "run a shell command inside the updater instead of the update, and exit with code 7 if it fails")
+	cmd.Flags().StringVar(&flags.execFile, "exec-file", "", "run a script inside the updater instead of the update, and exit with code 7 if it fails")
 	cmd.Flags().StringArrayVarP(&flags.volumes, "volume", "v", nil, "mount volumes in Docker")
+	cmd.Flags().StringVar(&flags.recordPath, "record", "", "write the HTTP exchanges the proxy makes to a cassette file")
+	cmd.Flags().StringVar(&flags.replayPath, "replay", "", "serve the proxy's HTTP exchanges from a cassette file instead of the internet")
+	cmd.Flags().StringVar(&flags.corePath, "core", "", "path to a dependabot-core checkout to mount the sources of in the updater")
+	cmd.Flags().StringVar(&flags.harPath, "har", "", "write a HAR archive of the HTTP exchanges the proxy makes, with credentials redacted")
+	cmd.Flags().IntVar(&flags.harBodyLimit, "har-body-limit", 1<<20, "most bytes of each body to keep in the HAR archive, 0 to leave bodies out")
+	cmd.Flags().StringArrayVar(&flags.allowedHosts, "allow-host", nil, "only let the proxy reach this host and the ones the job needs, like *.example.com")
+	cmd.Flags().BoolVar(&flags.restrictEgress, "restrict-egress", false, "only let the proxy reach the hosts the job needs, even without --allow-host")
+	cmd.Flags().StringVar(&flags.apiFaultsPath, "api-faults", "", "path to a fault profile of delays, errors, and dropped connections for the fake API to inject")
+	cmd.Flags().BoolVar(&flags.strictAPI, "strict-api", false, "answer API endpoints the CLI doesn't know with a 501 instead of recording them")
+	cmd.Flags().BoolVar(&flags.recordMetrics, "record-metrics", false, "keep the updater's metrics and ecosystem versions in the output and summary, and send the metrics to --otlp-endpoint")
+	cmd.Flags().StringArrayVar(&flags.forwardTo, "forward-to", nil, "URL to relay each API call the updater makes to, signed with $DEPENDABOT_FORWARD_SECRET if it's set")
+	cmd.Flags().IntVar(&flags.forwardBatch, "forward-batch", 0, "relay the API calls in batches of up to this many")
+	cmd.Flags().BoolVar(&flags.preflight, "preflight", false, "check each credential against its registry before the update")
+	cmd.Flags().StringArrayVar(&flags.allowedTokenPermissions, "allow-token-permission", nil, "repository permission a GitHub token may have, instead of pull and triage")
 	cmd.Flags().StringArrayVar(&flags.extraHosts, "extra-hosts", nil, "Docker extra hosts setting on the proxy")
 	cmd.Flags().DurationVarP(&flags.timeout, "timeout", "t", 0, "max time to run an update")
 	cmd.Flags().StringArrayVarP(&flags.updaterEnvironmentVariables, "updater-env", "e", nil, "additional environment variables to set in the update container")
@@ -35,4 +35,23 @@ func TestTestCommand(t *testing.T) {
 			t.Errorf("expected package manager to be set")
 		}
 	})
+
+	t.Run("Invalid params are a usage error", func(t *testing.T) {
+		executeTestJob = func(params infra.RunParams) error {
+			t.Fatalf("expected the job not to run")
+			return nil
+		}
+		cmd := NewTestCommand()
+		err := cmd.ParseFlags([]string{"-f", "../../../../testdata/smoke-test.yml", "--ca-key", "ca.key"})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		err = cmd.RunE(cmd, nil)
+		if exitCode(err) != ExitUsage {
+			t.Errorf("expected a usage error, got %v", err)
+		}
+		if cmd.SilenceUsage {
+			t.Errorf("expected the usage to be printed")
+		}
+	})
 }
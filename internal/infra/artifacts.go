@@ -0,0 +1,246 @@
+package infra
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dependabot/cli/internal/model"
+	"github.com/docker/docker/client"
+)
+
+const (
+	artifactManifest      = "run.json"
+	artifactJob           = "job.json"
+	artifactProxyConfig   = "proxy-config.json"
+	artifactEvents        = "events.jsonl"
+	artifactOutput        = "output.yml"
+	artifactSummary       = "summary.txt"
+	artifactFlamegraph    = "flamegraph.html"
+	artifactProxyLogs     = "proxy.log"
+	artifactUpdaterLogs   = "updater.log"
+	artifactCollectorLogs = "collector.log"
+)
+
+// redacted replaces secrets in the artifacts so the directory is safe to upload.
+const redacted = "[REDACTED]"
+
+// Artifacts collects the files produced by a run into a single directory.
+// A nil *Artifacts is valid and discards everything, so callers don't need to check if it's enabled.
+type Artifacts struct {
+	dir      string
+	mu       sync.Mutex
+	open     []*os.File
+	manifest Manifest
+}
+
+// Manifest is written to run.json and describes the run that produced the artifacts.
+type Manifest struct {
+	StartedAt  time.Time       `json:"started-at"`
+	FinishedAt time.Time       `json:"finished-at"`
+	Duration   float64         `json:"duration-seconds"`
+	Error      string          `json:"error,omitempty"`
+	Images     []ManifestImage `json:"images,omitempty"`
+	Phases     []Phase         `json:"phases,omitempty"`
+	Resources  []ResourceUsage `json:"resources,omitempty"`
+	Files      []string        `json:"files"`
+}
+
+// ManifestImage records exactly which image was used for a container.
+type ManifestImage struct {
+	Role    string   `json:"role"`
+	Name    string   `json:"name"`
+	ID      string   `json:"id,omitempty"`
+	Digests []string `json:"digests,omitempty"`
+}
+
+// NewArtifacts creates the artifact directory, returns nil if dir is empty.
+func NewArtifacts(dir string) (*Artifacts, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create artifacts directory: %w", err)
+	}
+	return &Artifacts{
+		dir: dir,
+		manifest: Manifest{
+			StartedAt: time.Now().UTC(),
+		},
+	}, nil
+}
+
+// Path returns where the named artifact is written, or fallback if artifacts aren't enabled.
+func (a *Artifacts) Path(name, fallback string) string {
+	if a == nil {
+		return fallback
+	}
+	a.addFile(name)
+	return filepath.Join(a.dir, name)
+}
+
+// WriteFile writes an artifact, failures are logged since artifacts shouldn't fail the run.
+func (a *Artifacts) WriteFile(name string, data []byte) {
+	if a == nil {
+		return
+	}
+	if err := os.WriteFile(a.Path(name, ""), data, 0600); err != nil {
+		log.Printf("failed to write artifact %s: %v", name, err)
+	}
+}
+
+// WriteJSON writes an indented JSON artifact.
+func (a *Artifacts) WriteJSON(name string, v any) {
+	if a == nil {
+		return
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		log.Printf("failed to marshal artifact %s: %v", name, err)
+		return
+	}
+	a.WriteFile(name, append(data, '\n'))
+}
+
+// Writer returns a writer that copies w into the named artifact.
+func (a *Artifacts) Writer(name string, w io.Writer) io.Writer {
+	if a == nil {
+		return w
+	}
+	f, err := a.create(name)
+	if err != nil {
+		return w
+	}
+	if w == nil {
+		return f
+	}
+	return io.MultiWriter(w, f)
+}
+
+// CaptureLogs writes every log line from the source into the named artifact.
+func (a *Artifacts) CaptureLogs(source, name string) {
+	if a == nil {
+		return
+	}
+	f, err := a.create(name)
+	if err != nil {
+		return
+	}
+	setLogCopy(source, f)
+}
+
+// RecordImage adds the ID and digests of an image to the manifest.
+func (a *Artifacts) RecordImage(ctx context.Context, cli *client.Client, role, name string) {
+	if a == nil {
+		return
+	}
+	image := ManifestImage{Role: role, Name: name}
+	if inspect, err := cli.ImageInspect(ctx, name); err == nil {
+		image.ID = inspect.ID
+		image.Digests = inspect.RepoDigests
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.manifest.Images = append(a.manifest.Images, image)
+}
+
+// RecordMetrics adds the phase timings and resource usage to the manifest.
+func (a *Artifacts) RecordMetrics(m *Metrics) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.manifest.Phases = m.Phases()
+	a.manifest.Resources = m.Resources()
+}
+
+// Close stops capturing logs and writes the manifest.
+func (a *Artifacts) Close(runErr error) {
+	if a == nil {
+		return
+	}
+	for _, source := range []string{LogSourceProxy, LogSourceUpdater, LogSourceOTel} {
+		setLogCopy(source, nil)
+	}
+	a.mu.Lock()
+	for _, f := range a.open {
+		_ = f.Close()
+	}
+	a.open = nil
+	a.manifest.FinishedAt = time.Now().UTC()
+	a.manifest.Duration = a.manifest.FinishedAt.Sub(a.manifest.StartedAt).Seconds()
+	if runErr != nil {
+		a.manifest.Error = runErr.Error()
+	}
+	a.manifest.Files = append(a.manifest.Files, artifactManifest)
+	sort.Strings(a.manifest.Files)
+	manifest := a.manifest
+	a.mu.Unlock()
+
+	a.WriteJSON(artifactManifest, manifest)
+	log.Println("wrote run artifacts to", a.dir)
+}
+
+func (a *Artifacts) create(name string) (*os.File, error) {
+	f, err := os.Create(a.Path(name, ""))
+	if err != nil {
+		log.Printf("failed to create artifact %s: %v", name, err)
+		return nil, err
+	}
+	a.mu.Lock()
+	a.open = append(a.open, f)
+	a.mu.Unlock()
+	return f, nil
+}
+
+func (a *Artifacts) addFile(name string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, f := range a.manifest.Files {
+		if f == name {
+			return
+		}
+	}
+	if name != artifactManifest {
+		a.manifest.Files = append(a.manifest.Files, name)
+	}
+}
+
+// redactConfig returns a copy of the proxy config without the credential secrets or the CA key.
+func redactConfig(config *Config) *Config {
+	creds := make([]model.Credential, 0, len(config.Credentials))
+	for _, cred := range config.Credentials {
+		copied := model.Credential{}
+		for k, v := range cred {
+			if isSecretCredentialKey(k) {
+				v = redacted
+			}
+			copied[k] = v
+		}
+		creds = append(creds, copied)
+	}
+	return &Config{
+		Credentials: creds,
+		CA: CertificateAuthority{
+			Cert: config.CA.Cert,
+			Key:  redacted,
+		},
+	}
+}
+
+// isSecretCredentialKey reports whether a credential field holds a secret that must not be shown.
+func isSecretCredentialKey(key string) bool {
+	switch key {
+	case "username", "password", "token", "key", "auth-key":
+		return true
+	}
+	return false
+}
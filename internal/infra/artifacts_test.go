@@ -0,0 +1,93 @@
+package infra
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dependabot/cli/internal/model"
+)
+
+func TestArtifacts(t *testing.T) {
+	t.Run("nil artifacts discard everything", func(t *testing.T) {
+		artifacts, err := NewArtifacts("")
+		if err != nil || artifacts != nil {
+			t.Fatalf("expected no artifacts, got %v %v", artifacts, err)
+		}
+		artifacts.WriteFile(artifactOutput, []byte("output"))
+		artifacts.CaptureLogs(LogSourceProxy, artifactProxyLogs)
+		if w := artifacts.Writer(artifactEvents, nil); w != nil {
+			t.Error("expected a nil writer")
+		}
+		if path := artifacts.Path(artifactFlamegraph, "flamegraph.html"); path != "flamegraph.html" {
+			t.Errorf("expected the fallback path, got %s", path)
+		}
+		artifacts.Close(nil)
+	})
+
+	t.Run("collects files and writes a manifest", func(t *testing.T) {
+		_ = withLogOutput(t, LogFormatText)
+		dir := filepath.Join(t.TempDir(), "artifacts")
+		artifacts, err := NewArtifacts(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		artifacts.WriteFile(artifactOutput, []byte("output"))
+		artifacts.CaptureLogs(LogSourceProxy, artifactProxyLogs)
+		w := LogWriter(LogSourceProxy, LogStreamStderr)
+		_, _ = w.Write([]byte("proxy started\n"))
+		artifacts.Close(nil)
+
+		logs, err := os.ReadFile(filepath.Join(dir, artifactProxyLogs))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(logs) != "proxy started\n" {
+			t.Errorf("expected the proxy logs to be captured, got %q", logs)
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, artifactManifest))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var manifest Manifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			t.Fatal(err)
+		}
+		expected := []string{artifactOutput, artifactProxyLogs, artifactManifest}
+		if !reflect.DeepEqual(manifest.Files, expected) {
+			t.Errorf("expected files %v, got %v", expected, manifest.Files)
+		}
+		if manifest.FinishedAt.Before(manifest.StartedAt) {
+			t.Error("expected the manifest to record timings")
+		}
+	})
+}
+
+func Test_redactConfig(t *testing.T) {
+	config := &Config{
+		Credentials: []model.Credential{{
+			"type":     "npm_registry",
+			"registry": "https://npm.pkg.github.com",
+			"token":    "secret-token",
+		}},
+		CA: CertificateAuthority{Cert: "cert", Key: "secret-key"},
+	}
+
+	redactedConfig := redactConfig(config)
+
+	data, _ := json.Marshal(redactedConfig)
+	if strings.Contains(string(data), "secret") {
+		t.Errorf("expected secrets to be redacted: %s", data)
+	}
+	if redactedConfig.Credentials[0]["registry"] != "https://npm.pkg.github.com" {
+		t.Error("expected non-secret fields to be kept")
+	}
+	if config.Credentials[0]["token"] != "secret-token" {
+		t.Error("expected the original config to be unchanged")
+	}
+}
@@ -1,12 +1,21 @@
 package infra
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
 	"math/big"
+	"os"
+	"path/filepath"
 	"time"
 )
 
@@ -15,6 +24,18 @@ const (
 	keyExpiryYears = 2
 )
 
+// Key types of a generated CA.
+const (
+	KeyTypeRSA   = "rsa"
+	KeyTypeECDSA = "ecdsa"
+)
+
+// Files of the CA kept in the --ca-dir directory.
+const (
+	CACertFile = "ca.crt"
+	CAKeyFile  = "ca.key"
+)
+
 var CertSubject = pkix.Name{
 	CommonName:         "Dependabot Internal CA",
 	OrganizationalUnit: []string{"Dependabot"},
@@ -26,12 +47,17 @@ var CertSubject = pkix.Name{
 
 // GenerateCertificateAuthority generates a new proxy keypair CA
 func GenerateCertificateAuthority() (CertificateAuthority, error) {
-	key, pemKey, err := generateKey()
+	return generateCertificateAuthority(KeyTypeRSA, 0)
+}
+
+// generateCertificateAuthority generates a CA with a key of the type, valid for the duration or two years if it's zero.
+func generateCertificateAuthority(keyType string, validity time.Duration) (CertificateAuthority, error) {
+	key, pemKey, err := generateKey(keyType)
 	if err != nil {
 		return CertificateAuthority{}, err
 	}
 
-	pemCert, err := generateCert(key)
+	pemCert, err := generateCert(key, validity)
 	if err != nil {
 		return CertificateAuthority{}, err
 	}
@@ -42,33 +68,61 @@ func GenerateCertificateAuthority() (CertificateAuthority, error) {
 	}, nil
 }
 
-func generateKey() (*rsa.PrivateKey, string, error) {
-	key, err := rsa.GenerateKey(rand.Reader, keySize)
-	if err != nil {
-		return nil, "", err
-	}
-	kb := &pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(key),
+func generateKey(keyType string) (crypto.Signer, string, error) {
+	switch keyType {
+	case "", KeyTypeRSA:
+		key, err := rsa.GenerateKey(rand.Reader, keySize)
+		if err != nil {
+			return nil, "", err
+		}
+		kb := &pem.Block{
+			Type:  "RSA PRIVATE KEY",
+			Bytes: x509.MarshalPKCS1PrivateKey(key),
+		}
+		return key, string(pem.EncodeToMemory(kb)), nil
+	case KeyTypeECDSA:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, "", err
+		}
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, "", err
+		}
+		kb := &pem.Block{
+			Type:  "EC PRIVATE KEY",
+			Bytes: der,
+		}
+		return key, string(pem.EncodeToMemory(kb)), nil
 	}
-	return key, string(pem.EncodeToMemory(kb)), nil
+	return nil, "", fmt.Errorf("unknown key type %q, expected %s or %s", keyType, KeyTypeRSA, KeyTypeECDSA)
 }
 
-func generateCert(key *rsa.PrivateKey) (string, error) {
+func generateCert(key crypto.Signer, validity time.Duration) (string, error) {
 	notBefore := time.Now()
 	notAfter := notBefore.AddDate(keyExpiryYears, 0, 0)
+	if validity > 0 {
+		notAfter = notBefore.Add(validity)
+	}
 
 	template := x509.Certificate{
 		SerialNumber:          big.NewInt(1),
 		Subject:               CertSubject,
 		NotBefore:             notBefore,
 		NotAfter:              notAfter,
-		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageAny, x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
-		SignatureAlgorithm:    x509.SHA256WithRSA,
 		BasicConstraintsValid: true,
 		IsCA:                  true,
 	}
+	switch key.(type) {
+	case *rsa.PrivateKey:
+		// only RSA keys can encipher keys
+		template.KeyUsage |= x509.KeyUsageKeyEncipherment
+		template.SignatureAlgorithm = x509.SHA256WithRSA
+	case *ecdsa.PrivateKey:
+		template.SignatureAlgorithm = x509.ECDSAWithSHA256
+	}
 	cert, err := x509.CreateCertificate(rand.Reader, &template, &template, key.Public(), key)
 	if err != nil {
 		return "", err
@@ -79,3 +133,106 @@ func generateCert(key *rsa.PrivateKey) (string, error) {
 	}
 	return string(pem.EncodeToMemory(cb)), nil
 }
+
+// parseCertificateAuthority decodes the PEM certificate and key of a CA, and checks they belong together.
+func parseCertificateAuthority(ca CertificateAuthority) (*x509.Certificate, crypto.Signer, error) {
+	certBlock, _ := pem.Decode([]byte(ca.Cert))
+	if certBlock == nil {
+		return nil, nil, fmt.Errorf("failed to decode CA certificate")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse CA certificate: %w", err)
+	}
+	keyBlock, _ := pem.Decode([]byte(ca.Key))
+	if keyBlock == nil {
+		return nil, nil, fmt.Errorf("failed to decode CA key")
+	}
+	key, err := parsePrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse CA key: %w", err)
+	}
+	if !cert.IsCA {
+		return nil, nil, fmt.Errorf("the certificate isn't a CA")
+	}
+	if pub, ok := key.Public().(interface{ Equal(crypto.PublicKey) bool }); !ok || !pub.Equal(cert.PublicKey) {
+		return nil, nil, fmt.Errorf("the CA key doesn't match the certificate")
+	}
+	return cert, key, nil
+}
+
+// parsePrivateKey parses an RSA or ECDSA key in any of the encodings OpenSSL writes.
+func parsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported key, expected an RSA or ECDSA key")
+	}
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return key, nil
+	case *ecdsa.PrivateKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported %T key, expected an RSA or ECDSA key", key)
+}
+
+// loadCertificateAuthority reads a CA from PEM files and checks it can be used.
+func loadCertificateAuthority(certPath, keyPath string) (CertificateAuthority, error) {
+	cert, err := os.ReadFile(certPath)
+	if err != nil {
+		return CertificateAuthority{}, fmt.Errorf("failed to read CA certificate: %w", err)
+	}
+	key, err := os.ReadFile(keyPath)
+	if err != nil {
+		return CertificateAuthority{}, fmt.Errorf("failed to read CA key: %w", err)
+	}
+	ca := CertificateAuthority{Cert: string(cert), Key: string(key)}
+	parsed, _, err := parseCertificateAuthority(ca)
+	if err != nil {
+		return CertificateAuthority{}, fmt.Errorf("%s: %w", certPath, err)
+	}
+	if time.Now().After(parsed.NotAfter) {
+		return CertificateAuthority{}, fmt.Errorf("the CA in %s expired on %s", certPath, parsed.NotAfter.Format(time.DateOnly))
+	}
+	return ca, nil
+}
+
+// certificateAuthority returns the CA the proxy intercepts HTTPS with: the one given, the one kept in
+// the CA directory, which is created on first use, or a new one for the run.
+func certificateAuthority(params *RunParams) (CertificateAuthority, error) {
+	if params.CACertPath != "" {
+		return loadCertificateAuthority(params.CACertPath, params.CAKeyPath)
+	}
+	if params.CADir == "" {
+		return generateCertificateAuthority(params.CAKeyType, params.CAValidity)
+	}
+
+	certPath, keyPath := filepath.Join(params.CADir, CACertFile), filepath.Join(params.CADir, CAKeyFile)
+	// if only one of the files is there, loading fails rather than the other being replaced
+	_, certErr := os.Stat(certPath)
+	_, keyErr := os.Stat(keyPath)
+	if !errors.Is(certErr, fs.ErrNotExist) || !errors.Is(keyErr, fs.ErrNotExist) {
+		return loadCertificateAuthority(certPath, keyPath)
+	}
+	ca, err := generateCertificateAuthority(params.CAKeyType, params.CAValidity)
+	if err != nil {
+		return CertificateAuthority{}, err
+	}
+	if err = os.MkdirAll(params.CADir, 0700); err != nil {
+		return CertificateAuthority{}, fmt.Errorf("failed to create CA directory: %w", err)
+	}
+	if err = os.WriteFile(keyPath, []byte(ca.Key), 0600); err != nil {
+		return CertificateAuthority{}, fmt.Errorf("failed to write CA key: %w", err)
+	}
+	if err = os.WriteFile(certPath, []byte(ca.Cert), 0644); err != nil { //nolint:gosec // the certificate is public
+		return CertificateAuthority{}, fmt.Errorf("failed to write CA certificate: %w", err)
+	}
+	log.Println("created a CA in", params.CADir, "which will be reused by later runs")
+	return ca, nil
+}
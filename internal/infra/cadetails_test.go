@@ -1,8 +1,14 @@
 package infra
 
 import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestGenerateCaDetails(t *testing.T) {
@@ -17,3 +23,116 @@ func TestGenerateCaDetails(t *testing.T) {
 		t.Errorf("Expected certificate to contain BEGIN RSA PRIVATE KEY, got %s", ca.Key)
 	}
 }
+
+func Test_generateCertificateAuthority(t *testing.T) {
+	ca, err := generateCertificateAuthority(KeyTypeECDSA, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(ca.Key, "BEGIN EC PRIVATE KEY") {
+		t.Errorf("expected an EC key, got %s", ca.Key)
+	}
+	cert, key, err := parseCertificateAuthority(ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := key.(*ecdsa.PrivateKey); !ok {
+		t.Errorf("expected an ECDSA key, got %T", key)
+	}
+	if validity := cert.NotAfter.Sub(cert.NotBefore); validity != 24*time.Hour {
+		t.Errorf("expected the CA to be valid for a day, got %s", validity)
+	}
+	if cert.KeyUsage&x509.KeyUsageKeyEncipherment != 0 {
+		t.Error("expected an ECDSA CA not to have key encipherment usage")
+	}
+
+	if _, err = generateCertificateAuthority("dsa", 0); err == nil {
+		t.Error("expected an unknown key type to fail")
+	}
+}
+
+func Test_parseCertificateAuthority(t *testing.T) {
+	rsaCA, err := GenerateCertificateAuthority()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdsaCA, err := generateCertificateAuthority(KeyTypeECDSA, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("PKCS8 keys", func(t *testing.T) {
+		_, key, err := parseCertificateAuthority(ecdsaCA)
+		if err != nil {
+			t.Fatal(err)
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkcs8 := CertificateAuthority{Cert: ecdsaCA.Cert, Key: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))}
+		if _, _, err = parseCertificateAuthority(pkcs8); err != nil {
+			t.Errorf("expected a PKCS8 key to be parsed, got %v", err)
+		}
+	})
+	t.Run("key of another CA", func(t *testing.T) {
+		mismatched := CertificateAuthority{Cert: rsaCA.Cert, Key: ecdsaCA.Key}
+		if _, _, err := parseCertificateAuthority(mismatched); err == nil {
+			t.Error("expected a key that doesn't match the certificate to fail")
+		}
+	})
+}
+
+func Test_certificateAuthority(t *testing.T) {
+	t.Run("CA directory is reused", func(t *testing.T) {
+		params := &RunParams{CADir: filepath.Join(t.TempDir(), "ca"), CAKeyType: KeyTypeECDSA}
+		first, err := certificateAuthority(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := certificateAuthority(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first != second {
+			t.Error("expected the CA in the directory to be reused")
+		}
+		info, err := os.Stat(filepath.Join(params.CADir, CAKeyFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("expected the key to only be readable by the user, got %v", info.Mode())
+		}
+	})
+	t.Run("CA directory missing the key", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, CACertFile), []byte("cert"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := certificateAuthority(&RunParams{CADir: dir}); err == nil {
+			t.Error("expected a CA directory with only a certificate to fail")
+		}
+	})
+	t.Run("CA files", func(t *testing.T) {
+		ca, err := GenerateCertificateAuthority()
+		if err != nil {
+			t.Fatal(err)
+		}
+		dir := t.TempDir()
+		params := &RunParams{CACertPath: filepath.Join(dir, "org.crt"), CAKeyPath: filepath.Join(dir, "org.key")}
+		if err = os.WriteFile(params.CACertPath, []byte(ca.Cert), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(params.CAKeyPath, []byte(ca.Key), 0600); err != nil {
+			t.Fatal(err)
+		}
+		actual, err := certificateAuthority(params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual != ca {
+			t.Error("expected the CA in the files to be used")
+		}
+	})
+}
@@ -0,0 +1,137 @@
+package infra
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Cassette is a recording of the HTTP exchanges the proxy made with registries during a run.
+type Cassette struct {
+	Interactions []Interaction `yaml:"interactions"`
+}
+
+// Interaction is a single recorded request and its response. Request headers aren't recorded
+// since they contain the credentials injected by the proxy.
+type Interaction struct {
+	Request  RecordedRequest  `yaml:"request"`
+	Response RecordedResponse `yaml:"response"`
+}
+
+// RecordedRequest identifies a request by its method and URL.
+type RecordedRequest struct {
+	Method string `yaml:"method"`
+	URL    string `yaml:"url"`
+}
+
+// RecordedResponse is a response with its body as text, or base64 when it's binary.
+type RecordedResponse struct {
+	Status     int                 `yaml:"status"`
+	Headers    map[string][]string `yaml:"headers,omitempty"`
+	Body       string              `yaml:"body,omitempty"`
+	BodyBase64 string              `yaml:"body-base64,omitempty"`
+}
+
+// droppedResponseHeaders are removed from recorded responses, since they're either recomputed or shouldn't be replayed.
+var droppedResponseHeaders = []string{"Connection", "Content-Length", "Keep-Alive", "Set-Cookie", "Transfer-Encoding"}
+
+// newRecordedResponse records a response with the secrets redacted, like in a HAR archive,
+// since cassettes are meant to be committed. A binary body is kept as it is.
+func newRecordedResponse(status int, header http.Header, body []byte, secrets []string) RecordedResponse {
+	headers := header.Clone()
+	for _, h := range droppedResponseHeaders {
+		headers.Del(h)
+	}
+	for name, vs := range headers {
+		for i, v := range vs {
+			for _, r := range redactedHeaders {
+				if strings.EqualFold(name, r) {
+					v = redacted
+				}
+			}
+			vs[i] = redactSecrets(v, secrets)
+		}
+	}
+	r := RecordedResponse{Status: status, Headers: headers}
+	if utf8.Valid(body) {
+		r.Body = redactSecrets(string(body), secrets)
+	} else {
+		r.BodyBase64 = base64.StdEncoding.EncodeToString(body)
+	}
+	return r
+}
+
+func (r *RecordedResponse) body() ([]byte, error) {
+	if r.BodyBase64 != "" {
+		return base64.StdEncoding.DecodeString(r.BodyBase64)
+	}
+	return []byte(r.Body), nil
+}
+
+// LoadCassette reads a cassette written by test --record.
+func LoadCassette(path string) (*Cassette, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cassette: %w", err)
+	}
+	var cassette Cassette
+	if err = yaml.Unmarshal(data, &cassette); err != nil {
+		return nil, fmt.Errorf("failed to parse cassette: %w", err)
+	}
+	return &cassette, nil
+}
+
+// Save writes the cassette as YAML.
+func (c *Cassette) Save(path string) error {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to marshal cassette: %w", err)
+	}
+	if err = os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write cassette: %w", err)
+	}
+	return nil
+}
+
+// replayer serves the responses in a cassette in the order they were recorded. When a request is
+// made more times than it was recorded, the last response is repeated.
+type replayer struct {
+	mu        sync.Mutex
+	responses map[string][]RecordedResponse
+	misses    []string
+}
+
+func newReplayer(cassette *Cassette) *replayer {
+	r := &replayer{responses: map[string][]RecordedResponse{}}
+	for _, i := range cassette.Interactions {
+		key := interactionKey(i.Request.Method, i.Request.URL)
+		r.responses[key] = append(r.responses[key], i.Response)
+	}
+	return r
+}
+
+func interactionKey(method, url string) string {
+	return strings.ToUpper(method) + " " + url
+}
+
+// next returns the response for a request, or false if it wasn't recorded.
+func (r *replayer) next(method, url string) (RecordedResponse, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := interactionKey(method, url)
+	responses := r.responses[key]
+	if len(responses) == 0 {
+		r.misses = append(r.misses, key)
+		return RecordedResponse{}, false
+	}
+	if len(responses) > 1 {
+		r.responses[key] = responses[1:]
+	}
+	return responses[0], true
+}
@@ -0,0 +1,115 @@
+package infra
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+)
+
+// customCertsDir is where update-ca-certificates picks up extra certificates in the proxy and updater images.
+const customCertsDir = "/usr/local/share/ca-certificates"
+
+// readCertificates reads the PEM certificates in files, bundles, and directories of them, returning each one
+// on its own since update-ca-certificates expects a single certificate per file.
+func readCertificates(paths []string) ([]string, error) {
+	var certs []string
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read certificate: %w", err)
+		}
+		files := []string{p}
+		if info.IsDir() {
+			if files, err = certificateFiles(p); err != nil {
+				return nil, err
+			}
+		}
+		for _, f := range files {
+			fileCerts, err := readCertificateFile(f)
+			if err != nil {
+				return nil, err
+			}
+			certs = append(certs, fileCerts...)
+		}
+	}
+	return certs, nil
+}
+
+// certificateFiles returns the .crt and .pem files in a directory, in name order.
+func certificateFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate directory: %w", err)
+	}
+	var files []string
+	for _, e := range entries {
+		ext := strings.ToLower(filepath.Ext(e.Name()))
+		if !e.IsDir() && (ext == ".crt" || ext == ".pem") {
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no .crt or .pem files in certificate directory %s", dir)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func readCertificateFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate: %w", err)
+	}
+	var certs []string
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		// bundles can have keys or other blocks in them, only the certificates are trusted
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if _, err = x509.ParseCertificate(block.Bytes); err != nil {
+			return nil, fmt.Errorf("failed to parse certificate in %s: %w", path, err)
+		}
+		certs = append(certs, string(pem.EncodeToMemory(block)))
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no PEM certificates in %s", path)
+	}
+	return certs, nil
+}
+
+// putCertificates copies the certificates to the container, where the next update-ca-certificates installs them.
+func putCertificates(ctx context.Context, cli *client.Client, id, prefix string, certs []string) error {
+	if len(certs) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	t := tar.NewWriter(&buf)
+	for i, cert := range certs {
+		name := fmt.Sprintf("%s/%s-%d.crt", customCertsDir, prefix, i+1)
+		if err := addFileToArchive(t, name, 0644, cert); err != nil {
+			return fmt.Errorf("adding certificate to archive: %w", err)
+		}
+	}
+	if err := t.Close(); err != nil {
+		return fmt.Errorf("failed to create cert tarball: %w", err)
+	}
+	if err := cli.CopyToContainer(ctx, id, "/", &buf, container.CopyToContainerOptions{}); err != nil {
+		return fmt.Errorf("failed to copy certificates to container: %w", err)
+	}
+	return nil
+}
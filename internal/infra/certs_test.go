@@ -0,0 +1,74 @@
+package infra
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_readCertificates(t *testing.T) {
+	var certs []string
+	for range 3 {
+		ca, err := GenerateCertificateAuthority()
+		if err != nil {
+			t.Fatal(err)
+		}
+		certs = append(certs, ca.Cert)
+	}
+	write := func(t *testing.T, path, content string) string {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	t.Run("bundle is split", func(t *testing.T) {
+		key, err := GenerateCertificateAuthority()
+		if err != nil {
+			t.Fatal(err)
+		}
+		bundle := write(t, filepath.Join(t.TempDir(), "bundle.pem"), certs[0]+key.Key+certs[1])
+		actual, err := readCertificates([]string{bundle})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(actual) != 2 || actual[0] != certs[0] || actual[1] != certs[1] {
+			t.Errorf("expected the two certificates in the bundle without the key, got %d", len(actual))
+		}
+	})
+	t.Run("directory and repeated paths", func(t *testing.T) {
+		dir := t.TempDir()
+		write(t, filepath.Join(dir, "b.crt"), certs[1])
+		write(t, filepath.Join(dir, "a.pem"), certs[0])
+		write(t, filepath.Join(dir, "README"), "not a certificate")
+		file := write(t, filepath.Join(t.TempDir(), "c.crt"), certs[2])
+
+		actual, err := readCertificates([]string{dir, file})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(actual) != 3 {
+			t.Fatalf("expected 3 certificates, got %d", len(actual))
+		}
+		for i := range certs {
+			if actual[i] != certs[i] {
+				t.Errorf("expected certificate %d to be read in name order", i)
+			}
+		}
+	})
+	t.Run("errors", func(t *testing.T) {
+		dir := t.TempDir()
+		cases := map[string]string{
+			"missing file":        filepath.Join(dir, "missing.crt"),
+			"no certificates":     write(t, filepath.Join(dir, "empty.crt"), "nothing here\n"),
+			"empty directory":     t.TempDir(),
+			"invalid certificate": write(t, filepath.Join(dir, "bad.crt"), "-----BEGIN CERTIFICATE-----\nbm90IGEgY2VydA==\n-----END CERTIFICATE-----\n"),
+		}
+		for name, path := range cases {
+			if _, err := readCertificates([]string{path}); err == nil {
+				t.Errorf("%s: expected an error", name)
+			}
+		}
+	})
+}
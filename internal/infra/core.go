@@ -0,0 +1,56 @@
+package infra
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// coreEcosystemDirs are the package managers whose directory in dependabot-core isn't named after them.
+var coreEcosystemDirs = map[string]string{
+	"pip":        "python",
+	"submodules": "git_submodules",
+}
+
+// coreEcosystemDeps are the other ecosystems a package manager's gem loads, whose sources are mounted with its own.
+var coreEcosystemDeps = map[string][]string{
+	"bun":            {"npm_and_yarn"},
+	"docker_compose": {"docker"},
+	"helm":           {"docker"},
+	"uv":             {"python"},
+}
+
+// coreVolumes returns the volumes that mount the sources in a dependabot-core checkout over the ones
+// in the updater image, so edits are picked up without rebuilding it. Only the Ruby sources are mounted,
+// since the image has the native helpers built next to them.
+func coreVolumes(corePath, packageManager string) ([]string, error) {
+	if _, ok := packageManagerLookup[packageManager]; !ok {
+		return nil, fmt.Errorf("unknown package manager: %s", packageManager)
+	}
+	ecosystem := packageManager
+	if dir, ok := coreEcosystemDirs[packageManager]; ok {
+		ecosystem = dir
+	}
+
+	root, err := filepath.Abs(corePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve dependabot-core path: %w", err)
+	}
+	mounts := []struct{ local, remote string }{
+		{"updater/bin", "/home/dependabot/dependabot-updater/bin"},
+		{"updater/lib", "/home/dependabot/dependabot-updater/lib"},
+		{"common/lib", "/home/dependabot/common/lib"},
+	}
+	for _, dir := range append([]string{ecosystem}, coreEcosystemDeps[packageManager]...) {
+		mounts = append(mounts, struct{ local, remote string }{dir + "/lib", "/home/dependabot/" + dir + "/lib"})
+	}
+	volumes := make([]string, 0, len(mounts))
+	for _, m := range mounts {
+		local := filepath.Join(root, filepath.FromSlash(m.local))
+		if info, err := os.Stat(local); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("%s doesn't look like a dependabot-core checkout with %s support, expected the directory %s to exist", corePath, packageManager, m.local)
+		}
+		volumes = append(volumes, local+":"+m.remote)
+	}
+	return volumes, nil
+}
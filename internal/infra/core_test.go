@@ -0,0 +1,79 @@
+package infra
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_coreVolumes(t *testing.T) {
+	core := t.TempDir()
+	for _, dir := range []string{"updater/bin", "updater/lib", "common/lib", "python/lib", "go_modules/lib", "uv/lib", "bun/lib"} {
+		if err := os.MkdirAll(filepath.Join(core, dir), 0750); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("mounts the ecosystem", func(t *testing.T) {
+		volumes, err := coreVolumes(core, "go_modules")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []string{
+			filepath.Join(core, "updater/bin") + ":/home/dependabot/dependabot-updater/bin",
+			filepath.Join(core, "updater/lib") + ":/home/dependabot/dependabot-updater/lib",
+			filepath.Join(core, "common/lib") + ":/home/dependabot/common/lib",
+			filepath.Join(core, "go_modules/lib") + ":/home/dependabot/go_modules/lib",
+		}
+		if !reflect.DeepEqual(volumes, expected) {
+			t.Errorf("expected %v, got %v", expected, volumes)
+		}
+	})
+	t.Run("ecosystem directory with a different name", func(t *testing.T) {
+		volumes, err := coreVolumes(core, "pip")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if last := volumes[len(volumes)-1]; !strings.HasSuffix(last, ":/home/dependabot/python/lib") {
+			t.Errorf("expected pip to mount the python directory, got %s", last)
+		}
+	})
+	t.Run("ecosystem that loads another", func(t *testing.T) {
+		volumes, err := coreVolumes(core, "uv")
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []string{
+			filepath.Join(core, "uv/lib") + ":/home/dependabot/uv/lib",
+			filepath.Join(core, "python/lib") + ":/home/dependabot/python/lib",
+		}
+		if !reflect.DeepEqual(volumes[3:], expected) {
+			t.Errorf("expected uv to mount the python directory too, got %v", volumes)
+		}
+	})
+	t.Run("missing dependency", func(t *testing.T) {
+		_, err := coreVolumes(core, "bun")
+		if err == nil || !strings.Contains(err.Error(), "npm_and_yarn/lib") {
+			t.Errorf("expected an error naming the missing directory, got %v", err)
+		}
+	})
+	t.Run("missing ecosystem", func(t *testing.T) {
+		_, err := coreVolumes(core, "bundler")
+		if err == nil || !strings.Contains(err.Error(), "bundler/lib") {
+			t.Errorf("expected an error naming the missing directory, got %v", err)
+		}
+	})
+	t.Run("not a checkout", func(t *testing.T) {
+		_, err := coreVolumes(t.TempDir(), "go_modules")
+		if err == nil || !strings.Contains(err.Error(), "doesn't look like a dependabot-core checkout") {
+			t.Errorf("expected a layout error, got %v", err)
+		}
+	})
+	t.Run("unknown package manager", func(t *testing.T) {
+		if _, err := coreVolumes(core, "cobol"); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
@@ -0,0 +1,264 @@
+package infra
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dependabot/cli/internal/model"
+	"github.com/docker/docker/client"
+)
+
+// CredentialResult is the outcome of checking a credential against its registry.
+type CredentialResult string
+
+const (
+	CredentialOK           CredentialResult = "OK"
+	CredentialUnauthorized CredentialResult = "unauthorized"
+	CredentialNotFound     CredentialResult = "not found"
+	CredentialUnreachable  CredentialResult = "unreachable"
+	CredentialUnknown      CredentialResult = "unknown"
+	// CredentialUnverified is a successful response from a registry that doesn't show whether the
+	// credential was accepted, since it has no endpoint that requires one.
+	CredentialUnverified CredentialResult = "unverified"
+)
+
+// CredentialCheck is the result of checking one credential. It never holds the credential's secrets.
+type CredentialCheck struct {
+	Type   string
+	Host   string
+	Result CredentialResult
+	Detail string
+}
+
+// ErrCredentialCheck is returned when a credential didn't pass its check.
+var ErrCredentialCheck = errors.New("credentials failed the check")
+
+// CheckCredentials makes a request to the registry of each credential through the proxy,
+// which injects the credential like it would for the updater.
+func CheckCredentials(ctx context.Context, params RunParams) ([]CredentialCheck, error) {
+	expandEnvironmentVariables(nil, &params)
+	if params.ProxyImage == "" {
+		params.ProxyImage = ProxyImageName
+	}
+	return checkCredentials(ctx, params)
+}
+
+// checkCredentials expects the credentials to have been expanded from the environment.
+func checkCredentials(ctx context.Context, params RunParams) (checks []CredentialCheck, err error) {
+	ctx, span := tracer.Start(ctx, "check credentials")
+	defer span.End()
+
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, &infrastructureError{err: fmt.Errorf("failed to create Docker client: %w", err)}
+	}
+	if params.PullImages {
+		if err = pullImage(ctx, cli, params.ProxyImage); err != nil {
+			return nil, err
+		}
+	}
+
+	networks, err := NewNetworks(ctx, cli)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create networks: %w", err)
+	}
+	defer func() { _ = networks.Close() }()
+
+	// the checks shouldn't be recorded or restricted like the update
+	params.publishProxy = true
+	params.recorder = nil
+	prox, err := NewProxy(ctx, cli, &params, networks)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = prox.Close() }()
+	if prox.hostURL == "" {
+		return nil, fmt.Errorf("the proxy's port wasn't published")
+	}
+
+	proxyURL, err := url.Parse(prox.hostURL)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM([]byte(prox.ca.Cert))
+	httpClient := &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyURL(proxyURL),
+			TLSClientConfig: &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12},
+		},
+		Timeout: 30 * time.Second,
+		// a redirect to a login page would look like success
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	if err = waitForProxy(ctx, prox.hostURL); err != nil {
+		return nil, err
+	}
+
+	for _, cred := range params.Creds {
+		checks = append(checks, checkCredential(ctx, httpClient, cred))
+	}
+	return checks, nil
+}
+
+// preflight checks the credentials before an update, so a wrong one fails fast.
+func preflight(ctx context.Context, params RunParams) error {
+	checks, err := checkCredentials(ctx, params)
+	if err != nil {
+		return &infrastructureError{err: fmt.Errorf("failed to check credentials: %w", err)}
+	}
+	w := LogWriter(LogSourceCLI, LogStreamStderr)
+	_ = WriteCredentialChecks(w, checks)
+	_ = w.Close()
+	return FailedCredentials(checks)
+}
+
+// waitForProxy waits until the proxy answers, since it installs certificates before listening.
+func waitForProxy(ctx context.Context, proxyURL string) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, proxyURL, http.NoBody)
+		if err != nil {
+			return err
+		}
+		if resp, err := http.DefaultClient.Do(req); err == nil {
+			resp.Body.Close()
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("the proxy didn't start: %w", ctx.Err())
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+}
+
+func checkCredential(ctx context.Context, httpClient *http.Client, cred model.Credential) CredentialCheck {
+	credType, _ := cred["type"].(string)
+	check := CredentialCheck{Type: credType}
+
+	target, verifies := credentialTarget(cred)
+	if target == nil {
+		check.Result = CredentialUnreachable
+		check.Detail = "no host, url, or registry to check"
+		return check
+	}
+	check.Host = target.Host
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), http.NoBody)
+	if err != nil {
+		check.Result = CredentialUnreachable
+		check.Detail = "invalid URL"
+		return check
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		check.Result = CredentialUnreachable
+		check.Detail = "request failed"
+		return check
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	check.Result = credentialResult(resp.StatusCode)
+	if check.Result == CredentialOK && !verifies {
+		check.Result = CredentialUnverified
+	}
+	check.Detail = fmt.Sprintf("HTTP %d", resp.StatusCode)
+	return check
+}
+
+// credentialTarget returns the URL to check a credential against, without any user info. A public registry
+// answers its root whatever the credential, so for the types that have one, it's an endpoint that requires
+// authentication, and verifies is true.
+func credentialTarget(cred model.Credential) (target *url.URL, verifies bool) {
+	for _, key := range []string{"url", "registry", "index-url", "host"} {
+		v, ok := cred[key].(string)
+		if !ok || v == "" {
+			continue
+		}
+		if !strings.Contains(v, "://") {
+			v = "https://" + v
+		}
+		u, err := url.Parse(v)
+		if err != nil || u.Host == "" {
+			continue
+		}
+		u.User = nil
+		switch cred["type"] {
+		case "docker_registry":
+			// registries implementing the Docker API only authenticate below /v2/
+			u.Path = "/v2/"
+			return u, true
+		case "npm_registry":
+			// what npm whoami calls, relative to the registry like the rest of its API
+			u.Path = strings.TrimSuffix(u.Path, "/") + "/-/whoami"
+			return u, true
+		case "git_source":
+			// the authenticated user, from the API of GitHub or GitHub Enterprise Server
+			if u.Host == "github.com" {
+				u.Host = "api.github.com"
+				u.Path = "/user"
+			} else {
+				u.Path = "/api/v3/user"
+			}
+			return u, true
+		}
+		return u, false
+	}
+	return nil, false
+}
+
+// credentialResult interprets the registry's response. Only a successful response is OK: a redirect is
+// likely to a login page, and other client errors don't show whether the credential works.
+func credentialResult(status int) CredentialResult {
+	switch {
+	case status >= 200 && status < 300:
+		return CredentialOK
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return CredentialUnauthorized
+	case status == http.StatusNotFound || status == http.StatusGone:
+		return CredentialNotFound
+	case status >= 500:
+		return CredentialUnreachable
+	default:
+		return CredentialUnknown
+	}
+}
+
+// WriteCredentialChecks prints the results as a table.
+func WriteCredentialChecks(w io.Writer, checks []CredentialCheck) error {
+	if len(checks) == 0 {
+		_, err := fmt.Fprintln(w, "No credentials to check")
+		return err
+	}
+	rows := make([][]string, 0, len(checks))
+	for _, c := range checks {
+		rows = append(rows, []string{c.Type, c.Host, string(c.Result), c.Detail})
+	}
+	return writeTable(w, "Credentials", []string{"type", "host", "result", "detail"}, rows)
+}
+
+// FailedCredentials returns an error wrapping ErrCredentialCheck if any check didn't pass.
+// An unverified credential passes, since there's nothing else to check it with.
+func FailedCredentials(checks []CredentialCheck) error {
+	var failed int
+	for _, c := range checks {
+		if c.Result != CredentialOK && c.Result != CredentialUnverified {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d %w", failed, len(checks), ErrCredentialCheck)
+	}
+	return nil
+}
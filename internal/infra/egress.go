@@ -0,0 +1,171 @@
+package infra
+
+import (
+	"log"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/dependabot/cli/internal/model"
+)
+
+// ecosystemHosts are the public registries each package manager needs, allowed by default when
+// the proxy's egress is restricted to an allowlist.
+var ecosystemHosts = map[string][]string{
+	"bazel":          {"bcr.bazel.build", "mirror.bazel.build"},
+	"bun":            {"registry.npmjs.org"},
+	"bundler":        {"rubygems.org", "index.rubygems.org"},
+	"cargo":          {"crates.io", "index.crates.io", "static.crates.io"},
+	"composer":       {"packagist.org", "repo.packagist.org"},
+	"conda":          {"conda.anaconda.org", "api.anaconda.org", "repo.anaconda.com", "pypi.org", "files.pythonhosted.org"},
+	"deno":           {"jsr.io", "registry.npmjs.org"},
+	"devcontainers":  {"ghcr.io", "mcr.microsoft.com"},
+	"docker":         {"registry-1.docker.io", "auth.docker.io", "production.cloudflare.docker.com"},
+	"docker_compose": {"registry-1.docker.io", "auth.docker.io", "production.cloudflare.docker.com"},
+	"dotnet_sdk":     {"dotnetcli.blob.core.windows.net", "builds.dotnet.microsoft.com"},
+	"elm":            {"package.elm-lang.org"},
+	"go_modules":     {"proxy.golang.org", "sum.golang.org"},
+	"gradle":         {"repo.maven.apache.org", "plugins.gradle.org", "services.gradle.org"},
+	"helm":           {"registry-1.docker.io", "auth.docker.io"},
+	"hex":            {"hex.pm", "repo.hex.pm"},
+	"julia":          {"pkg.julialang.org"},
+	"maven":          {"repo.maven.apache.org"},
+	"nix":            {"cache.nixos.org", "channels.nixos.org"},
+	"npm_and_yarn":   {"registry.npmjs.org", "registry.yarnpkg.com"},
+	"nuget":          {"api.nuget.org"},
+	"opentofu":       {"registry.opentofu.org"},
+	"pip":            {"pypi.org", "files.pythonhosted.org"},
+	"pub":            {"pub.dev"},
+	"rust_toolchain": {"static.rust-lang.org"},
+	"sbt":            {"repo.maven.apache.org", "repo.scala-sbt.org"},
+	"terraform":      {"registry.terraform.io", "releases.hashicorp.com"},
+	"uv":             {"pypi.org", "files.pythonhosted.org"},
+	// these only fetch from git repositories, the GitHub ones are always allowed
+	"github_actions": {},
+	"pre_commit":     {},
+	"submodules":     {},
+	"swift":          {},
+	"vcpkg":          {},
+}
+
+// githubHosts are needed to fetch the repository and any git dependencies hosted on GitHub.
+var githubHosts = []string{"github.com", "api.github.com", "codeload.github.com", "*.githubusercontent.com"}
+
+// egressAllowlist returns the hosts the proxy may reach: the ones given, the ones in the credentials,
+// the source repository's, and the defaults for the package manager.
+func egressAllowlist(allowed []string, job *model.Job, creds []model.Credential) []string {
+	hosts := append([]string{}, allowed...)
+	for _, cred := range creds {
+		for _, key := range []string{"host", "url", "registry", "index-url"} {
+			if v, ok := cred[key].(string); ok && v != "" {
+				hosts = append(hosts, hostOf(v))
+			}
+		}
+	}
+	if job != nil {
+		hosts = append(hosts, ecosystemHosts[job.PackageManager]...)
+		if job.Source.Hostname != nil && *job.Source.Hostname != "" && *job.Source.Hostname != "github.com" {
+			hosts = append(hosts, *job.Source.Hostname)
+		} else {
+			hosts = append(hosts, githubHosts...)
+		}
+		if job.Source.APIEndpoint != nil && *job.Source.APIEndpoint != "" {
+			hosts = append(hosts, hostOf(*job.Source.APIEndpoint))
+		}
+	}
+
+	seen := map[string]bool{}
+	// not nil even if it's empty, since that allows everything
+	list := []string{}
+	for _, h := range hosts {
+		h = strings.ToLower(strings.TrimSpace(h))
+		if h != "" && !seen[h] {
+			seen[h] = true
+			list = append(list, h)
+		}
+	}
+	sort.Strings(list)
+	return list
+}
+
+// allowedEgress returns the hosts the proxy may reach, or nil when its egress isn't restricted.
+func (p *RunParams) allowedEgress() []string {
+	if !p.RestrictEgress && len(p.AllowedHosts) == 0 {
+		return nil
+	}
+	return egressAllowlist(p.AllowedHosts, p.Job, p.Creds)
+}
+
+// hostOf returns the host of a credential value, which may be a URL or a host with a path.
+func hostOf(v string) string {
+	if strings.Contains(v, "://") {
+		if u, err := url.Parse(v); err == nil {
+			return u.Hostname()
+		}
+	}
+	host, _, _ := strings.Cut(v, "/")
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+// BlockedHost is a host the proxy tried to reach that isn't in the allowlist.
+type BlockedHost struct {
+	Host     string
+	Requests int
+}
+
+// egressPolicy decides which hosts the proxy may reach. A nil *egressPolicy allows everything.
+type egressPolicy struct {
+	allowed []string
+
+	mu      sync.Mutex
+	blocked map[string]int
+}
+
+func newEgressPolicy(allowed []string) *egressPolicy {
+	return &egressPolicy{allowed: allowed, blocked: map[string]int{}}
+}
+
+// allow reports whether the host may be reached, and counts it as blocked if not.
+// A "*." prefix allows every subdomain.
+func (p *egressPolicy) allow(host string) bool {
+	if p == nil {
+		return true
+	}
+	host = strings.ToLower(host)
+	for _, a := range p.allowed {
+		if a == host {
+			return true
+		}
+		if suffix, ok := strings.CutPrefix(a, "*."); ok && strings.HasSuffix(host, "."+suffix) {
+			return true
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.blocked[host] == 0 {
+		log.Printf("blocked a request to %s, it isn't an allowed host", host)
+	}
+	p.blocked[host]++
+	return false
+}
+
+// blockedHosts returns the hosts that were blocked and how often.
+func (p *egressPolicy) blockedHosts() []BlockedHost {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var hosts []BlockedHost
+	for host, n := range p.blocked {
+		hosts = append(hosts, BlockedHost{Host: host, Requests: n})
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Host < hosts[j].Host })
+	return hosts
+}
@@ -0,0 +1,126 @@
+package infra
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/dependabot/cli/internal/model"
+)
+
+func Test_egressAllowlist(t *testing.T) {
+	t.Run("github.com source", func(t *testing.T) {
+		job := &model.Job{PackageManager: "npm_and_yarn"}
+		creds := []model.Credential{
+			{"type": "npm_registry", "registry": "npm.pkg.github.com/my-org", "token": "secret"},
+			{"type": "python_index", "index-url": "https://pypi.example.com:8443/simple"},
+			{"type": "git_source", "host": "GitHub.com"},
+		}
+		expected := []string{
+			"*.githubusercontent.com",
+			"api.github.com",
+			"codeload.github.com",
+			"extra.example.com",
+			"github.com",
+			"npm.pkg.github.com",
+			"pypi.example.com",
+			"registry.npmjs.org",
+			"registry.yarnpkg.com",
+		}
+		if actual := egressAllowlist([]string{"extra.example.com"}, job, creds); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("expected %v, got %v", expected, actual)
+		}
+	})
+	t.Run("GitHub Enterprise Server source", func(t *testing.T) {
+		hostname, apiEndpoint := "ghes.example.com", "https://ghes.example.com/api/v3"
+		job := &model.Job{PackageManager: "go_modules", Source: model.Source{Hostname: &hostname, APIEndpoint: &apiEndpoint}}
+		expected := []string{"ghes.example.com", "proxy.golang.org", "sum.golang.org"}
+		if actual := egressAllowlist(nil, job, nil); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("expected %v, got %v", expected, actual)
+		}
+	})
+}
+
+func Test_ecosystemHosts(t *testing.T) {
+	// an ecosystem without defaults would be broken by --restrict-egress
+	for packageManager := range packageManagerLookup {
+		if _, ok := ecosystemHosts[packageManager]; !ok {
+			t.Errorf("expected default hosts for %s", packageManager)
+		}
+	}
+}
+
+func TestRunParams_allowedEgress(t *testing.T) {
+	job := &model.Job{PackageManager: "go_modules"}
+	tests := []struct {
+		name     string
+		params   RunParams
+		expected []string
+	}{
+		{"unrestricted", RunParams{Job: job}, nil},
+		{"allowed hosts", RunParams{Job: job, AllowedHosts: []string{"goproxy.example.com"}}, []string{"*.githubusercontent.com", "api.github.com", "codeload.github.com", "github.com", "goproxy.example.com", "proxy.golang.org", "sum.golang.org"}},
+		{"defaults only", RunParams{Job: job, RestrictEgress: true}, []string{"*.githubusercontent.com", "api.github.com", "codeload.github.com", "github.com", "proxy.golang.org", "sum.golang.org"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := tt.params.allowedEgress(); !reflect.DeepEqual(actual, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func Test_egressPolicy(t *testing.T) {
+	p := newEgressPolicy([]string{"registry.npmjs.org", "*.example.com", "*ample.org"})
+	for host, allowed := range map[string]bool{
+		"registry.npmjs.org":   true,
+		"REGISTRY.npmjs.org":   true,
+		"npm.example.com":      true,
+		"example.com":          false,
+		"evil.com":             false,
+		"notexample.com":       false,
+		"evilexample.com":      false,
+		"a.b.example.com":      true,
+		"example.org":          false,
+		"registry.npmjs.org.x": false,
+	} {
+		if p.allow(host) != allowed {
+			t.Errorf("expected %s allowed to be %v", host, allowed)
+		}
+	}
+	p.allow("evil.com")
+
+	expected := []BlockedHost{{"evil.com", 2}, {"evilexample.com", 1}, {"example.com", 1}, {"example.org", 1}, {"notexample.com", 1}, {"registry.npmjs.org.x", 1}}
+	if actual := p.blockedHosts(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+
+	var unrestricted *egressPolicy
+	if !unrestricted.allow("evil.com") || unrestricted.blockedHosts() != nil {
+		t.Error("expected a nil policy to allow everything")
+	}
+}
+
+func TestRecorder_allowedHosts(t *testing.T) {
+	registry := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer registry.Close()
+
+	recorder, err := newTestRecorder(RecorderOptions{AllowedHosts: []string{"registry.npmjs.org"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recorder.Close()
+	recorder.transport = registry.Client().Transport
+	client := recorderClient(t, recorder)
+
+	if _, err = client.Get(registry.URL); err == nil {
+		t.Error("expected the request to be blocked")
+	}
+	expected := []BlockedHost{{Host: "127.0.0.1", Requests: 1}}
+	if actual := recorder.Blocked(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
@@ -0,0 +1,54 @@
+package infra
+
+import (
+	"fmt"
+)
+
+// ErrInfrastructure matches failures in the environment the update runs in, such as Docker, networking, or image pulls.
+var ErrInfrastructure = fmt.Errorf("infrastructure failure")
+
+// infrastructureError marks an error as an infrastructure failure without changing its message.
+type infrastructureError struct {
+	err error
+}
+
+func (e *infrastructureError) Error() string {
+	return e.err.Error()
+}
+
+func (e *infrastructureError) Unwrap() error {
+	return e.err
+}
+
+func (e *infrastructureError) Is(target error) bool {
+	return target == ErrInfrastructure
+}
+
+// UpdaterExitError is returned when the updater exits with a non-zero code.
+type UpdaterExitError struct {
+	Code int
+}
+
+func (e *UpdaterExitError) Error() string {
+	return fmt.Sprintf("updater exited with code %d", e.Code)
+}
+
+// ExecExitError is returned when the command run with --exec or --exec-file exits with a non-zero code.
+type ExecExitError struct {
+	Code int
+}
+
+func (e *ExecExitError) Error() string {
+	return fmt.Sprintf("exec exited with code %d", e.Code)
+}
+
+// JobError is returned when the updater finished the job by reporting an error to the API,
+// for example, dependency_file_not_found.
+type JobError struct {
+	ErrorType    string         `json:"error-type"`
+	ErrorDetails map[string]any `json:"error-details,omitempty"`
+}
+
+func (e *JobError) Error() string {
+	return fmt.Sprintf("update job failed with %s", e.ErrorType)
+}
@@ -0,0 +1,55 @@
+package infra
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/dependabot/cli/internal/server"
+	"github.com/hexops/gotextdiff"
+	"github.com/hexops/gotextdiff/myers"
+	"github.com/hexops/gotextdiff/span"
+)
+
+// reportFailures writes why each expectation failed: the path of every field that differs with its expected
+// and actual values, and a text diff of multi-line values like file contents.
+func reportFailures(w io.Writer, errs []error) {
+	for _, err := range errs {
+		var mismatch *server.MismatchError
+		if !errors.As(err, &mismatch) {
+			// the rest of the message is the whole body, which is in the output file
+			message, _, _ := strings.Cut(err.Error(), "\n")
+			_, _ = fmt.Fprintln(w, message)
+			continue
+		}
+		_, _ = fmt.Fprintf(w, "output[%d] %s doesn't match:\n", mismatch.Index, mismatch.Type)
+		for _, field := range mismatch.Fields {
+			expected, expectedString := field.Expected.(string)
+			actual, actualString := field.Actual.(string)
+			if expectedString && actualString && (strings.Contains(expected, "\n") || strings.Contains(actual, "\n")) {
+				edits := myers.ComputeEdits(span.URIFromPath(field.Path), expected, actual)
+				unified := fmt.Sprint(gotextdiff.ToUnified("expected", "actual", expected, edits))
+				_, _ = fmt.Fprintf(w, "  %s:\n    %s\n", field.Path, strings.ReplaceAll(strings.TrimSuffix(unified, "\n"), "\n", "\n    "))
+				continue
+			}
+			_, _ = fmt.Fprintf(w, "  %s:\n    expected: %s\n    actual:   %s\n", field.Path, formatField(field.Expected), formatField(field.Actual))
+		}
+	}
+}
+
+func formatField(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "missing"
+	case string:
+		return strconv.Quote(v)
+	case map[string]any, []any:
+		if data, err := json.Marshal(v); err == nil {
+			return string(data)
+		}
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,47 @@
+package infra
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/dependabot/cli/internal/server"
+)
+
+func Test_reportFailures(t *testing.T) {
+	errs := []error{
+		&server.MismatchError{
+			Index: 1,
+			Type:  "create_pull_request",
+			Fields: []server.FieldDiff{
+				{Path: "$.dependencies[0].version", Expected: "7.1.2", Actual: "7.1.3"},
+				{Path: "$.pr-body", Expected: nil, Actual: "Bumps rails."},
+				{Path: "$.updated-dependency-files[0].content", Expected: "gem \"rails\"\nrails (7.1.2)\n", Actual: "gem \"rails\"\nrails (7.1.3)\n"},
+			},
+			Err: fmt.Errorf("unexpected body for create_pull_request"),
+		},
+		fmt.Errorf("expectation not met: mark_as_processed\n{abc}"),
+	}
+	var buf bytes.Buffer
+	reportFailures(&buf, errs)
+
+	expected := `output[1] create_pull_request doesn't match:
+  $.dependencies[0].version:
+    expected: "7.1.2"
+    actual:   "7.1.3"
+  $.pr-body:
+    expected: missing
+    actual:   "Bumps rails."
+  $.updated-dependency-files[0].content:
+    --- expected
+    +++ actual
+    @@ -1,2 +1,2 @@
+     gem "rails"
+    -rails (7.1.2)
+    +rails (7.1.3)
+expectation not met: mark_as_processed
+`
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
@@ -0,0 +1,232 @@
+package infra
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/dependabot/cli/internal/model"
+	"github.com/dependabot/cli/internal/server"
+	"gopkg.in/yaml.v3"
+)
+
+// updateSmokeTest rewrites the output section of the smoke test file with the outputs, and prints which
+// expectations changed. The rest of the file, comments included, is left as it is.
+func updateSmokeTest(params RunParams, outputs []model.Output) error {
+	updated, changes, err := updateExpectations(params.InputRaw, params.Expected, outputs)
+	if err != nil {
+		return fmt.Errorf("failed to update %s: %w", params.InputName, err)
+	}
+	w := LogWriter(LogSourceCLI, LogStreamStderr)
+	defer w.Close()
+	if len(changes) == 0 {
+		_, _ = fmt.Fprintf(w, "expectations in %s are up to date\n", params.InputName)
+		return nil
+	}
+	info, err := os.Stat(params.InputName)
+	if err != nil {
+		return fmt.Errorf("failed to update %s: %w", params.InputName, err)
+	}
+	if err = os.WriteFile(params.InputName, updated, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to update %s: %w", params.InputName, err)
+	}
+	_, _ = fmt.Fprintf(w, "updated expectations in %s:\n", params.InputName)
+	for _, change := range changes {
+		_, _ = fmt.Fprintf(w, "  %s\n", change)
+	}
+	return nil
+}
+
+// updateExpectations replaces the output section of the smoke test with the outputs. An expectation an output
+// still meets is kept as it's written, with its matchers and comments, the others are replaced. It returns the
+// new smoke test and a description of each expectation that was added, changed, or removed.
+func updateExpectations(raw []byte, expected, outputs []model.Output) ([]byte, []string, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(raw, &doc); err != nil {
+		return nil, nil, err
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, nil, fmt.Errorf("expected the smoke test to be a map")
+	}
+	root := doc.Content[0]
+
+	var items []*yaml.Node
+	outputIndex := -1
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		if root.Content[i].Value == "output" {
+			outputIndex = i + 1
+			if root.Content[i+1].Kind == yaml.SequenceNode {
+				items = root.Content[i+1].Content
+			}
+		}
+	}
+	if len(items) != len(expected) {
+		// the expectations were decoded from the same file, so this shouldn't happen
+		return nil, nil, fmt.Errorf("expected %d outputs in the smoke test, found %d", len(expected), len(items))
+	}
+
+	var changes []string
+	used := make([]bool, len(expected))
+	section := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+	if outputIndex >= 0 {
+		section.Style = root.Content[outputIndex].Style
+	}
+	for i := range outputs {
+		out := &outputs[i]
+		kept := -1
+		for j := range expected {
+			if !used[j] && server.MatchesExpectation(&expected[j], out) {
+				kept = j
+				break
+			}
+		}
+		if kept >= 0 {
+			used[kept] = true
+			section.Content = append(section.Content, items[kept])
+			continue
+		}
+		var node yaml.Node
+		if err := node.Encode(out); err != nil {
+			return nil, nil, err
+		}
+		section.Content = append(section.Content, &node)
+		if i < len(expected) && !used[i] && expected[i].Type == out.Type {
+			// the output replaces the expectation at its position
+			used[i] = true
+			changes = append(changes, fmt.Sprintf("changed output[%d] %s", i, out.Type))
+		} else {
+			changes = append(changes, fmt.Sprintf("added output[%d] %s", i, out.Type))
+		}
+	}
+	for j := range expected {
+		if !used[j] {
+			changes = append(changes, fmt.Sprintf("removed %s, was output[%d]", expected[j].Type, j))
+		}
+	}
+
+	if root.Style&yaml.FlowStyle != 0 {
+		// a JSON smoke test is written again as a whole, as JSON with its keys in the same order
+		if outputIndex >= 0 {
+			root.Content[outputIndex] = section
+		} else {
+			root.Content = append(root.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "output"}, section)
+		}
+		out, err := encodeJSON(root)
+		return out, changes, err
+	}
+
+	// only the lines of the output section are replaced, so the rest of the file is exactly as it was
+	key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "output"}
+	if outputIndex >= 0 {
+		key = root.Content[outputIndex-1]
+	}
+	// the key's own comment is kept with the lines before it
+	sectionKey := *key
+	sectionKey.HeadComment = ""
+	text, err := encodeYAML(&yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Content: []*yaml.Node{&sectionKey, section}})
+	if err != nil {
+		return nil, nil, err
+	}
+	if outputIndex < 0 {
+		if !bytes.HasSuffix(raw, []byte("\n")) {
+			text = append([]byte("\n"), text...)
+		}
+		return append(raw, text...), changes, nil
+	}
+	lines := strings.SplitAfter(string(raw), "\n")
+	start, end := key.Line-1, len(lines)
+	if outputIndex+1 < len(root.Content) {
+		next := root.Content[outputIndex+1]
+		end = next.Line - 1
+		if next.HeadComment != "" {
+			end -= strings.Count(next.HeadComment, "\n") + 1
+		}
+	}
+	for end > start+1 && strings.TrimSpace(lines[end-1]) == "" {
+		end--
+	}
+	return []byte(strings.Join(lines[:start], "") + string(text) + strings.Join(lines[end:], "")), changes, nil
+}
+
+// encodeJSON writes the node as indented JSON, keeping the order of the keys in each map.
+func encodeJSON(node *yaml.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, node); err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, buf.Bytes(), "", "  "); err != nil {
+		return nil, err
+	}
+	out.WriteByte('\n')
+	return out.Bytes(), nil
+}
+
+func writeJSON(buf *bytes.Buffer, node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		return writeJSON(buf, node.Content[0])
+	case yaml.AliasNode:
+		return writeJSON(buf, node.Alias)
+	case yaml.MappingNode:
+		buf.WriteByte('{')
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeJSONValue(buf, node.Content[i].Value); err != nil {
+				return err
+			}
+			buf.WriteByte(':')
+			if err := writeJSON(buf, node.Content[i+1]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case yaml.SequenceNode:
+		buf.WriteByte('[')
+		for i, item := range node.Content {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeJSON(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	default:
+		var value any
+		if err := node.Decode(&value); err != nil {
+			return fmt.Errorf("can't write %s as JSON: %w", node.Tag, err)
+		}
+		return writeJSONValue(buf, value)
+	}
+	return nil
+}
+
+func writeJSONValue(buf *bytes.Buffer, value any) error {
+	enc := json.NewEncoder(buf)
+	// the smoke test's content is kept as it is, like the < and > of version requirements
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(value); err != nil {
+		return err
+	}
+	// Encode ends the value with a newline
+	buf.Truncate(buf.Len() - 1)
+	return nil
+}
+
+func encodeYAML(node *yaml.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(4)
+	if err := enc.Encode(node); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
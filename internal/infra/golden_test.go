@@ -0,0 +1,139 @@
+package infra
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dependabot/cli/internal/model"
+	"gopkg.in/yaml.v3"
+)
+
+func Test_updateExpectations(t *testing.T) {
+	raw := `# bumps the base image
+input:
+    job:
+        package-manager: docker
+        source:
+            directory: /  # the Dockerfile's directory
+            repo: dependabot/smoke-tests
+order: strict
+output:
+    # the SHA changes with every commit
+    - type: mark_as_processed
+      expect:
+        data:
+            base-commit-sha: !regex '^[0-9a-f]+$'
+    - type: record_update_job_error
+      expect:
+        data:
+            error-type: dependency_file_not_found
+            error-details: {}
+
+# faults aren't compared
+injected-faults: []
+`
+	var smokeTest model.SmokeTest
+	if err := yaml.Unmarshal([]byte(raw), &smokeTest); err != nil {
+		t.Fatal(err)
+	}
+	outputs := []model.Output{
+		{Type: "mark_as_processed", Expect: model.UpdateWrapper{Data: model.MarkAsProcessed{BaseCommitSha: "abc123"}}},
+		{Type: "record_update_job_error", Expect: model.UpdateWrapper{Data: model.RecordUpdateJobError{ErrorType: "unknown_error", ErrorDetails: map[string]any{}}}},
+		{Type: "record_update_job_unknown_error", Expect: model.UpdateWrapper{Data: model.RecordUpdateJobUnknownError{ErrorType: "unknown_error", ErrorDetails: map[string]any{}}}},
+	}
+
+	updated, changes, err := updateExpectations([]byte(raw), smokeTest.Output, outputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedChanges := []string{"changed output[1] record_update_job_error", "added output[2] record_update_job_unknown_error"}
+	if !reflect.DeepEqual(changes, expectedChanges) {
+		t.Errorf("expected changes %q, got %q", expectedChanges, changes)
+	}
+
+	input, _, _ := strings.Cut(raw, "output:")
+	if !strings.HasPrefix(string(updated), input) {
+		t.Errorf("expected the input to be unchanged, got:\n%s", updated)
+	}
+	if !strings.HasSuffix(string(updated), "error-details: {}\n\n# faults aren't compared\ninjected-faults: []\n") {
+		t.Errorf("expected the keys after the output to be unchanged, got:\n%s", updated)
+	}
+	for _, kept := range []string{"# the SHA changes with every commit", `base-commit-sha: !regex '^[0-9a-f]+$'`, "error-type: unknown_error"} {
+		if !strings.Contains(string(updated), kept) {
+			t.Errorf("expected %q in the updated smoke test:\n%s", kept, updated)
+		}
+	}
+	var result model.SmokeTest
+	if err := yaml.Unmarshal(updated, &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Output) != 3 || result.Order != model.OrderStrict {
+		t.Errorf("unexpected smoke test %+v", result)
+	}
+
+	t.Run("up to date", func(t *testing.T) {
+		_, changes, err := updateExpectations(updated, result.Output, outputs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(changes) != 0 {
+			t.Errorf("expected no changes, got %q", changes)
+		}
+	})
+	t.Run("removed", func(t *testing.T) {
+		_, changes, err := updateExpectations([]byte(raw), smokeTest.Output, outputs[:1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := []string{"removed record_update_job_error, was output[1]"}; !reflect.DeepEqual(changes, expected) {
+			t.Errorf("expected changes %q, got %q", expected, changes)
+		}
+	})
+	t.Run("JSON", func(t *testing.T) {
+		raw := `{
+  "input": {
+    "job": {
+      "source": {"repo": "dependabot/smoke-tests", "directory": "/"},
+      "package-manager": "docker",
+      "ignore-conditions": [{"dependency-name": "ubuntu", "version-requirement": ">= 23"}]
+    }
+  },
+  "output": [
+    {"type": "mark_as_processed", "expect": {"data": {"base-commit-sha": "abc123"}}}
+  ]
+}`
+		var smokeTest model.SmokeTest
+		if err := yaml.Unmarshal([]byte(raw), &smokeTest); err != nil {
+			t.Fatal(err)
+		}
+		updated, changes, err := updateExpectations([]byte(raw), smokeTest.Output, outputs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(changes) != 2 {
+			t.Errorf("expected 2 changes, got %q", changes)
+		}
+		if !json.Valid(updated) {
+			t.Fatalf("expected the smoke test to be written as JSON, got:\n%s", updated)
+		}
+		for _, kept := range []string{
+			"\"repo\": \"dependabot/smoke-tests\",\n        \"directory\": \"/\"",
+			`"version-requirement": ">= 23"`,
+			`"base-commit-sha": "abc123"`,
+			`"error-type": "unknown_error"`,
+		} {
+			if !strings.Contains(string(updated), kept) {
+				t.Errorf("expected %q in the updated smoke test:\n%s", kept, updated)
+			}
+		}
+		var result model.SmokeTest
+		if err := json.Unmarshal(updated, &result); err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Output) != 3 || result.Input.Job.PackageManager != "docker" {
+			t.Errorf("unexpected smoke test %+v", result)
+		}
+	})
+}
@@ -0,0 +1,293 @@
+package infra
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dependabot/cli/internal/model"
+)
+
+// HAR is an HTTP Archive 1.2 of the exchanges the proxy made, as written by --har.
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+// HARLog is the root of a HAR archive.
+type HARLog struct {
+	Version string     `json:"version"`
+	Creator HARCreator `json:"creator"`
+	Entries []HAREntry `json:"entries"`
+}
+
+// HARCreator is the application that wrote the archive.
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// HAREntry is a single request and its response.
+type HAREntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+
+	started time.Time
+}
+
+// HARRequest is the request of an entry.
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+// HARResponse is the response of an entry.
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+// HARNameValue is a header, cookie, or query parameter.
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// HARPostData is the body of a request.
+type HARPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// HARContent is the body of a response. Size is the full size even when Text was truncated.
+type HARContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// HARTimings breaks down the time of an entry in milliseconds.
+type HARTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// Save writes the archive as JSON.
+func (h *HAR) Save(path string) error {
+	data, err := json.MarshalIndent(h, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal HAR: %w", err)
+	}
+	if err = os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write HAR: %w", err)
+	}
+	return nil
+}
+
+// redactedHeaders always carry credentials, whatever their value looks like.
+var redactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// harCapture collects the entries of a HAR archive. A nil *harCapture is valid and captures nothing.
+type harCapture struct {
+	bodyLimit int
+	secrets   []string
+
+	mu      sync.Mutex
+	entries []HAREntry
+}
+
+func newHARCapture(bodyLimit int, creds []model.Credential) *harCapture {
+	return &harCapture{bodyLimit: bodyLimit, secrets: credentialSecrets(creds)}
+}
+
+// credentialSecrets returns the secrets the proxy may inject into requests, longest first so a secret
+// containing another is redacted whole. Usernames are left alone, they're rarely secret and often short.
+func credentialSecrets(creds []model.Credential) []string {
+	var secrets []string
+	for _, cred := range creds {
+		for k, v := range cred {
+			if s, ok := v.(string); ok && s != "" && k != "username" && isSecretCredentialKey(k) {
+				secrets = append(secrets, s)
+			}
+		}
+	}
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+	return secrets
+}
+
+func (h *harCapture) redact(s string) string {
+	return redactSecrets(s, h.secrets)
+}
+
+func redactSecrets(s string, secrets []string) string {
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, redacted)
+	}
+	return s
+}
+
+func (h *harCapture) headers(header http.Header) []HARNameValue {
+	values := []HARNameValue{}
+	for name, vs := range header {
+		for _, v := range vs {
+			for _, r := range redactedHeaders {
+				if strings.EqualFold(name, r) {
+					v = redacted
+				}
+			}
+			values = append(values, HARNameValue{Name: name, Value: h.redact(v)})
+		}
+	}
+	sort.SliceStable(values, func(i, j int) bool { return values[i].Name < values[j].Name })
+	return values
+}
+
+func (h *harCapture) url(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return h.redact(rawURL)
+	}
+	if _, ok := u.User.Password(); ok {
+		u.User = url.UserPassword(u.User.Username(), redacted)
+	}
+	return h.redact(u.String())
+}
+
+// body returns the body as text, or base64 when it's binary, cut to the body limit.
+func (h *harCapture) body(body []byte) (text, encoding, comment string) {
+	if !utf8.Valid(body) {
+		if len(body) > h.bodyLimit {
+			body = body[:h.bodyLimit]
+			comment = fmt.Sprintf("truncated to %d bytes", h.bodyLimit)
+		}
+		return base64.StdEncoding.EncodeToString(body), "base64", comment
+	}
+	// redact before cutting, so a secret at the limit isn't partly kept
+	text = h.redact(string(body))
+	if len(text) > h.bodyLimit {
+		// the cut may split a character
+		text = strings.ToValidUTF8(text[:h.bodyLimit], "")
+		comment = fmt.Sprintf("truncated to %d bytes", h.bodyLimit)
+	}
+	return text, "", comment
+}
+
+// add records an exchange that started at started and took elapsed.
+func (h *harCapture) add(req *http.Request, rawURL string, reqBody []byte, status int, header http.Header, body []byte, started time.Time, elapsed time.Duration) {
+	if h == nil {
+		return
+	}
+
+	entryURL := h.url(rawURL)
+	request := HARRequest{
+		Method:      req.Method,
+		URL:         entryURL,
+		HTTPVersion: "HTTP/1.1",
+		Cookies:     []HARNameValue{},
+		Headers:     h.headers(req.Header),
+		QueryString: []HARNameValue{},
+		HeadersSize: -1,
+		BodySize:    len(reqBody),
+	}
+	if u, err := url.Parse(entryURL); err == nil {
+		for name, vs := range u.Query() {
+			for _, v := range vs {
+				request.QueryString = append(request.QueryString, HARNameValue{Name: name, Value: v})
+			}
+		}
+		sort.SliceStable(request.QueryString, func(i, j int) bool { return request.QueryString[i].Name < request.QueryString[j].Name })
+	}
+	if len(reqBody) > 0 {
+		text, encoding, comment := h.body(reqBody)
+		if encoding != "" {
+			// postData can't be encoded, so a binary body is described instead
+			text, comment = "", fmt.Sprintf("%d bytes of binary data", len(reqBody))
+		}
+		request.PostData = &HARPostData{MimeType: req.Header.Get("Content-Type"), Text: text, Comment: comment}
+	}
+
+	text, encoding, comment := h.body(body)
+	response := HARResponse{
+		Status:      status,
+		StatusText:  http.StatusText(status),
+		HTTPVersion: "HTTP/1.1",
+		Cookies:     []HARNameValue{},
+		Headers:     h.headers(header),
+		Content: HARContent{
+			Size:     len(body),
+			MimeType: header.Get("Content-Type"),
+			Text:     text,
+			Encoding: encoding,
+			Comment:  comment,
+		},
+		RedirectURL: header.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    len(body),
+	}
+
+	ms := float64(elapsed) / float64(time.Millisecond)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries = append(h.entries, HAREntry{
+		StartedDateTime: started.UTC().Format(time.RFC3339Nano),
+		Time:            ms,
+		Request:         request,
+		Response:        response,
+		Timings:         HARTimings{Wait: ms},
+		started:         started,
+	})
+}
+
+// har returns the archive of the entries captured so far, in the order they started.
+func (h *harCapture) har() *HAR {
+	h.mu.Lock()
+	entries := append([]HAREntry(nil), h.entries...)
+	h.mu.Unlock()
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].started.Before(entries[j].started) })
+	if entries == nil {
+		entries = []HAREntry{}
+	}
+	return &HAR{Log: HARLog{
+		Version: "1.2",
+		Creator: HARCreator{Name: "dependabot-cli", Version: cliVersion()},
+		Entries: entries,
+	}}
+}
+
+func cliVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "0.0.0-dev"
+}
@@ -0,0 +1,139 @@
+package infra
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dependabot/cli/internal/model"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/resource"
+)
+
+// MetricCount is how many times the updater incremented a metric with the same tags.
+type MetricCount struct {
+	Name  string
+	Tags  map[string]string
+	Count int
+}
+
+// tagString formats the tags in name order, like "package_manager=bundler,result=success".
+func (m MetricCount) tagString() string {
+	pairs := make([]string, 0, len(m.Tags))
+	for k, v := range m.Tags {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// AggregateMetrics counts the increment_metric calls by metric name and tags.
+func AggregateMetrics(outputs []model.Output) []MetricCount {
+	counts := map[string]*MetricCount{}
+	for _, out := range outputs {
+		data, ok := out.Expect.Data.(model.IncrementMetric)
+		if !ok {
+			continue
+		}
+		m := MetricCount{Name: data.Metric, Tags: map[string]string{}}
+		for k, v := range data.Tags {
+			m.Tags[k] = fmt.Sprint(v)
+		}
+		key := m.Name + "\x00" + m.tagString()
+		if counts[key] == nil {
+			counts[key] = &m
+		}
+		counts[key].Count++
+	}
+
+	metrics := make([]MetricCount, 0, len(counts))
+	for _, m := range counts {
+		metrics = append(metrics, *m)
+	}
+	sort.Slice(metrics, func(i, j int) bool {
+		if metrics[i].Name != metrics[j].Name {
+			return metrics[i].Name < metrics[j].Name
+		}
+		return metrics[i].tagString() < metrics[j].tagString()
+	})
+	return metrics
+}
+
+// Ecosystems returns the distinct ecosystems of the record_ecosystem_meta calls, in the order they were reported.
+func Ecosystems(outputs []model.Output) []model.Ecosystem {
+	var ecosystems []model.Ecosystem
+	seen := map[string]bool{}
+	for _, out := range outputs {
+		metas, ok := out.Expect.Data.([]model.RecordEcosystemMeta)
+		if !ok {
+			continue
+		}
+		for _, meta := range metas {
+			e := meta.Ecosystem
+			key := strings.Join([]string{e.Name, e.PackageManager.Name, e.PackageManager.Version, e.Language.Name, e.Language.Version}, "\x00")
+			if !seen[key] {
+				seen[key] = true
+				ecosystems = append(ecosystems, e)
+			}
+		}
+	}
+	return ecosystems
+}
+
+// exportMetrics sends the metric counts to an OTLP/HTTP endpoint as counters, one for each metric name.
+func exportMetrics(ctx context.Context, endpoint string, metrics []MetricCount) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid OTLP endpoint: %w", err)
+	}
+	if strings.Trim(u.Path, "/") == "" {
+		u.Path = "/v1/metrics"
+	}
+	exporter, err := otlpmetrichttp.New(ctx, otlpmetrichttp.WithEndpointURL(u.String()))
+	if err != nil {
+		return fmt.Errorf("failed to create OTLP metric exporter: %w", err)
+	}
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(reader),
+		sdkmetric.WithResource(resource.NewSchemaless(attribute.String("service.name", "dependabot-cli"))),
+	)
+	meter := provider.Meter(TracerName)
+
+	counters := map[string]metric.Int64Counter{}
+	for _, m := range metrics {
+		counter, ok := counters[m.Name]
+		if !ok {
+			if counter, err = meter.Int64Counter(m.Name); err != nil {
+				log.Printf("not exporting metric %q: %v", m.Name, err)
+				continue
+			}
+			counters[m.Name] = counter
+		}
+		attrs := make([]attribute.KeyValue, 0, len(m.Tags))
+		for k, v := range m.Tags {
+			attrs = append(attrs, attribute.String(k, v))
+		}
+		counter.Add(ctx, int64(m.Count), metric.WithAttributes(attrs...))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	var collected metricdata.ResourceMetrics
+	if err = reader.Collect(ctx, &collected); err != nil {
+		return fmt.Errorf("failed to collect metrics: %w", err)
+	}
+	if err = exporter.Export(ctx, &collected); err != nil {
+		return fmt.Errorf("failed to export metrics: %w", err)
+	}
+	_ = provider.Shutdown(ctx)
+	return exporter.Shutdown(ctx)
+}
@@ -0,0 +1,65 @@
+package infra
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/dependabot/cli/internal/model"
+)
+
+func TestAggregateMetrics(t *testing.T) {
+	metric := func(name string, tags map[string]any) model.Output {
+		return model.Output{Type: "increment_metric", Expect: model.UpdateWrapper{Data: model.IncrementMetric{Metric: name, Tags: tags}}}
+	}
+	outputs := []model.Output{
+		metric("updater.started", map[string]any{"package_manager": "bundler"}),
+		metric("updater.retries", nil),
+		metric("updater.started", map[string]any{"package_manager": "bundler"}),
+		metric("updater.started", map[string]any{"package_manager": "npm_and_yarn"}),
+		{Type: "record_ecosystem_meta", Expect: model.UpdateWrapper{Data: []model.RecordEcosystemMeta{}}},
+	}
+
+	expected := []MetricCount{
+		{Name: "updater.retries", Tags: map[string]string{}, Count: 1},
+		{Name: "updater.started", Tags: map[string]string{"package_manager": "bundler"}, Count: 2},
+		{Name: "updater.started", Tags: map[string]string{"package_manager": "npm_and_yarn"}, Count: 1},
+	}
+	if actual := AggregateMetrics(outputs); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %+v, got %+v", expected, actual)
+	}
+}
+
+func TestEcosystems(t *testing.T) {
+	bundler := model.Ecosystem{Name: "bundler", PackageManager: model.VersionManager{Name: "bundler", Version: "2.7.2"}}
+	meta := model.Output{Type: "record_ecosystem_meta", Expect: model.UpdateWrapper{Data: []model.RecordEcosystemMeta{{Ecosystem: bundler}}}}
+
+	actual := Ecosystems([]model.Output{meta, meta})
+	if !reflect.DeepEqual(actual, []model.Ecosystem{bundler}) {
+		t.Errorf("expected the ecosystem once, got %+v", actual)
+	}
+}
+
+func Test_exportMetrics(t *testing.T) {
+	var path string
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	metrics := []MetricCount{{Name: "updater.started", Tags: map[string]string{"package_manager": "bundler"}, Count: 2}}
+	if err := exportMetrics(context.Background(), server.URL, metrics); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/v1/metrics" {
+		t.Errorf("expected the metrics to be sent to /v1/metrics, got %s", path)
+	}
+	if len(body) == 0 {
+		t.Error("expected the metrics to be sent")
+	}
+}
@@ -0,0 +1,179 @@
+package infra
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/dependabot/cli/internal/model"
+	"github.com/dependabot/cli/internal/server"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/moby/moby/pkg/namesgenerator"
+)
+
+// KeptRun records the containers and networks of a run kept with --keep-on-failure,
+// and what's needed to open a shell in the updater like the run did.
+type KeptRun struct {
+	ID                          string     `json:"id"`
+	UpdaterContainerID          string     `json:"updater-container-id"`
+	ProxyContainerID            string     `json:"proxy-container-id"`
+	CollectorContainerID        string     `json:"collector-container-id,omitempty"`
+	StorageContainerID          string     `json:"storage-container-id,omitempty"`
+	StorageVolumes              []string   `json:"storage-volumes,omitempty"`
+	NetworkIDs                  []string   `json:"network-ids"`
+	ProxyURL                    string     `json:"proxy-url"`
+	Job                         *model.Job `json:"job"`
+	UpdaterEnvironmentVariables []string   `json:"updater-environment-variables,omitempty"`
+}
+
+// ErrUnknownRun is returned when there are no kept containers for a run ID.
+var ErrUnknownRun = fmt.Errorf("no kept run with that ID")
+
+// keptRunsDir is where the state of each kept run is saved, it's overridden in tests.
+var keptRunsDir = func() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "dependabot", "runs"), nil
+}
+
+func keptRunPath(id string) (string, error) {
+	dir, err := keptRunsDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the kept runs directory: %w", err)
+	}
+	// the ID is user input, so don't let it escape the directory
+	if id == "" || filepath.Base(id) != id {
+		return "", fmt.Errorf("invalid run ID %q", id)
+	}
+	return filepath.Join(dir, id+".json"), nil
+}
+
+// keepRun saves the state of the run so the containers can be attached to and cleaned up later.
+func keepRun(params *RunParams, networks *Networks, prox *Proxy, collector *Collector, updater *Updater) (*KeptRun, error) {
+	run := &KeptRun{
+		ID:                          namesgenerator.GetRandomName(0),
+		UpdaterContainerID:          updater.containerID,
+		ProxyContainerID:            prox.containerID,
+		StorageContainerID:          updater.storageContainerID,
+		StorageVolumes:              updater.storageVolumes,
+		NetworkIDs:                  []string{networks.NoInternet.ID, networks.Internet.ID},
+		ProxyURL:                    prox.url,
+		Job:                         params.Job,
+		UpdaterEnvironmentVariables: params.UpdaterEnvironmentVariables,
+	}
+	if collector != nil {
+		run.CollectorContainerID = collector.containerID
+	}
+
+	path, err := keptRunPath(run.ID)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create the kept runs directory: %w", err)
+	}
+	data, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal kept run: %w", err)
+	}
+	// the environment variables may contain secrets
+	if err = os.WriteFile(path, data, 0600); err != nil {
+		return nil, fmt.Errorf("failed to save kept run: %w", err)
+	}
+	return run, nil
+}
+
+// LoadKeptRun reads the state of a run kept with --keep-on-failure.
+func LoadKeptRun(id string) (*KeptRun, error) {
+	path, err := keptRunPath(id)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownRun, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read kept run: %w", err)
+	}
+	var run KeptRun
+	if err = json.Unmarshal(data, &run); err != nil {
+		return nil, fmt.Errorf("failed to parse kept run: %w", err)
+	}
+	return &run, nil
+}
+
+// Attach opens a debug shell in the updater of a kept run, with the same environment the run used.
+// A new fake API is started for the updater to call, it doesn't record anything.
+func Attach(ctx context.Context, id string) error {
+	run, err := LoadKeptRun(id)
+	if err != nil {
+		return err
+	}
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return &infrastructureError{err: fmt.Errorf("failed to create Docker client: %w", err)}
+	}
+
+	api := server.NewAPI(nil, nil)
+	defer api.Stop()
+	apiURL := fmt.Sprintf("http://host.docker.internal:%v", api.Port())
+
+	updater := &Updater{cli: cli, containerID: run.UpdaterContainerID}
+	return updater.RunShell(ctx, run.ProxyURL, apiURL, run.Job, run.UpdaterEnvironmentVariables)
+}
+
+// Cleanup removes the containers, volumes, and networks of a kept run.
+func Cleanup(ctx context.Context, id string) error {
+	run, err := LoadKeptRun(id)
+	if err != nil {
+		return err
+	}
+	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return &infrastructureError{err: fmt.Errorf("failed to create Docker client: %w", err)}
+	}
+
+	// keep going so as much as possible is removed, the containers have to go before the networks
+	var errs []error
+	for _, containerID := range []string{run.UpdaterContainerID, run.StorageContainerID, run.CollectorContainerID, run.ProxyContainerID} {
+		if containerID == "" {
+			continue
+		}
+		if err = cli.ContainerRemove(ctx, containerID, container.RemoveOptions{Force: true}); err != nil && !cerrdefs.IsNotFound(err) {
+			errs = append(errs, fmt.Errorf("failed to remove container: %w", err))
+		}
+	}
+	for _, v := range run.StorageVolumes {
+		if err = cli.VolumeRemove(ctx, v, true); err != nil && !cerrdefs.IsNotFound(err) {
+			errs = append(errs, fmt.Errorf("failed to remove storage volume %s: %w", v, err))
+		}
+	}
+	for _, networkID := range run.NetworkIDs {
+		if err = cli.NetworkRemove(ctx, networkID); err != nil && !cerrdefs.IsNotFound(err) {
+			errs = append(errs, fmt.Errorf("failed to remove network: %w", err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	path, err := keptRunPath(id)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove kept run: %w", err)
+	}
+	log.Println("removed the containers and networks of run", id)
+	return nil
+}
@@ -0,0 +1,67 @@
+package infra
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dependabot/cli/internal/model"
+	"github.com/docker/docker/api/types/network"
+)
+
+func withKeptRunsDir(t *testing.T) string {
+	dir := t.TempDir()
+	original := keptRunsDir
+	keptRunsDir = func() (string, error) { return dir, nil }
+	t.Cleanup(func() { keptRunsDir = original })
+	return dir
+}
+
+func TestKeptRun(t *testing.T) {
+	t.Run("saves and loads a run", func(t *testing.T) {
+		dir := withKeptRunsDir(t)
+		params := &RunParams{
+			Job:                         &model.Job{PackageManager: "go_modules"},
+			UpdaterEnvironmentVariables: []string{"FOO=bar"},
+		}
+		networks := &Networks{NoInternet: network.CreateResponse{ID: "n1"}, Internet: network.CreateResponse{ID: "n2"}}
+		prox := &Proxy{containerID: "proxy", url: "http://1.2.3.4:1080"}
+		updater := &Updater{containerID: "updater", storageVolumes: []string{"v1"}}
+
+		run, err := keepRun(params, networks, prox, nil, updater)
+		if err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(filepath.Join(dir, run.ID+".json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("expected the state to only be readable by the user, got %v", info.Mode().Perm())
+		}
+
+		loaded, err := LoadKeptRun(run.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if loaded.UpdaterContainerID != "updater" || loaded.ProxyURL != "http://1.2.3.4:1080" || loaded.Job.PackageManager != "go_modules" {
+			t.Errorf("unexpected run %+v", loaded)
+		}
+		if len(loaded.NetworkIDs) != 2 || loaded.UpdaterEnvironmentVariables[0] != "FOO=bar" {
+			t.Errorf("unexpected run %+v", loaded)
+		}
+	})
+	t.Run("unknown run", func(t *testing.T) {
+		withKeptRunsDir(t)
+		if _, err := LoadKeptRun("missing"); !errors.Is(err, ErrUnknownRun) {
+			t.Errorf("expected ErrUnknownRun, got %v", err)
+		}
+	})
+	t.Run("rejects paths", func(t *testing.T) {
+		withKeptRunsDir(t)
+		if _, err := LoadKeptRun("../secrets"); err == nil || errors.Is(err, ErrUnknownRun) {
+			t.Errorf("expected an invalid run ID error, got %v", err)
+		}
+	})
+}
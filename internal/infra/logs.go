@@ -0,0 +1,156 @@
+package infra
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Log formats accepted by SetLogFormat.
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// Log sources identify which part of the run produced a log line.
+const (
+	LogSourceCLI     = "cli"
+	LogSourceProxy   = "proxy"
+	LogSourceUpdater = "updater"
+	LogSourceOTel    = "otel"
+)
+
+// Log streams identify which output of the process produced a log line.
+const (
+	LogStreamStdout = "stdout"
+	LogStreamStderr = "stderr"
+)
+
+var logPrefixes = map[string]string{
+	LogSourceProxy:   "  proxy | ",
+	LogSourceUpdater: "updater | ",
+	LogSourceOTel:    "   otel | ",
+}
+
+var (
+	// logMu serializes writes so lines from different containers don't interleave.
+	logMu     sync.Mutex
+	logFormat           = LogFormatText
+	logOutput io.Writer = os.Stderr
+	// logCopies receive every line from a source as plain text, e.g. to write it to an artifact.
+	logCopies = map[string]io.Writer{}
+)
+
+// SetLogFormat configures how the CLI and container logs are written to stderr.
+func SetLogFormat(format string) error {
+	switch format {
+	case LogFormatText:
+	case LogFormatJSON:
+		log.SetFlags(0)
+		log.SetPrefix("")
+		log.SetOutput(LogWriter(LogSourceCLI, LogStreamStderr))
+	default:
+		return fmt.Errorf("unknown log format %q, expected %q or %q", format, LogFormatText, LogFormatJSON)
+	}
+	logMu.Lock()
+	logFormat = format
+	logMu.Unlock()
+	return nil
+}
+
+// setLogCopy sends a copy of each line from the source to w, a nil w stops copying.
+func setLogCopy(source string, w io.Writer) {
+	logMu.Lock()
+	defer logMu.Unlock()
+	if w == nil {
+		delete(logCopies, source)
+		return
+	}
+	logCopies[source] = w
+}
+
+// logEntry is a single line of output in the JSON log format.
+type logEntry struct {
+	Time    string          `json:"time"`
+	Source  string          `json:"source"`
+	Stream  string          `json:"stream"`
+	Message string          `json:"message,omitempty"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+// LogWriter returns a writer which logs each line written to it as coming from the source and stream.
+// In the text format the line is prefixed with the source, CLI output is passed through as the
+// log package already adds a prefix. Close flushes any partial line.
+func LogWriter(source, stream string) io.WriteCloser {
+	return &lineWriter{source: source, stream: stream}
+}
+
+type lineWriter struct {
+	source string
+	stream string
+	buf    []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := w.buf[:i]
+		w.buf = w.buf[i+1:]
+		if err := writeLogLine(w.source, w.stream, line); err != nil {
+			return len(p), err
+		}
+	}
+	return len(p), nil
+}
+
+func (w *lineWriter) Close() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	line := w.buf
+	w.buf = nil
+	return writeLogLine(w.source, w.stream, line)
+}
+
+func writeLogLine(source, stream string, line []byte) error {
+	line = bytes.TrimSuffix(line, []byte("\r"))
+
+	logMu.Lock()
+	defer logMu.Unlock()
+
+	if w := logCopies[source]; w != nil {
+		_, _ = fmt.Fprintf(w, "%s\n", line)
+	}
+
+	if logFormat != LogFormatJSON {
+		_, err := fmt.Fprintf(logOutput, "%s%s\n", logPrefixes[source], line)
+		return err
+	}
+
+	entry := logEntry{
+		Time:   time.Now().UTC().Format(time.RFC3339Nano),
+		Source: source,
+		Stream: stream,
+	}
+	// Containers that already log JSON are nested rather than escaped, so they can be queried.
+	if trimmed := bytes.TrimSpace(line); len(trimmed) > 0 && trimmed[0] == '{' && json.Valid(trimmed) {
+		entry.Data = append(json.RawMessage(nil), trimmed...)
+	} else {
+		entry.Message = string(line)
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	_, err = logOutput.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,75 @@
+package infra
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func withLogOutput(t *testing.T, format string) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	oldOutput, oldFormat := logOutput, logFormat
+	logOutput, logFormat = &buf, format
+	t.Cleanup(func() {
+		logOutput, logFormat = oldOutput, oldFormat
+	})
+	return &buf
+}
+
+func TestLogWriter(t *testing.T) {
+	t.Run("text format prefixes each line with the source", func(t *testing.T) {
+		buf := withLogOutput(t, LogFormatText)
+
+		w := LogWriter(LogSourceProxy, LogStreamStdout)
+		_, _ = w.Write([]byte("first line\nsecond "))
+		_, _ = w.Write([]byte("line\npartial"))
+		_ = w.Close()
+
+		expected := "  proxy | first line\n  proxy | second line\n  proxy | partial\n"
+		if buf.String() != expected {
+			t.Errorf("expected %q, got %q", expected, buf.String())
+		}
+	})
+
+	t.Run("json format writes one object per line", func(t *testing.T) {
+		buf := withLogOutput(t, LogFormatJSON)
+
+		w := LogWriter(LogSourceUpdater, LogStreamStderr)
+		_, _ = w.Write([]byte("fetching files\n{\"level\":\"info\",\"msg\":\"done\"}\n"))
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if len(lines) != 2 {
+			t.Fatalf("expected 2 lines, got %d: %q", len(lines), buf.String())
+		}
+
+		var plain map[string]any
+		if err := json.Unmarshal([]byte(lines[0]), &plain); err != nil {
+			t.Fatal(err)
+		}
+		if plain["source"] != LogSourceUpdater || plain["stream"] != LogStreamStderr || plain["message"] != "fetching files" {
+			t.Errorf("unexpected entry %v", plain)
+		}
+		if plain["time"] == "" {
+			t.Error("expected a timestamp")
+		}
+
+		var nested struct {
+			Message string         `json:"message"`
+			Data    map[string]any `json:"data"`
+		}
+		if err := json.Unmarshal([]byte(lines[1]), &nested); err != nil {
+			t.Fatal(err)
+		}
+		if nested.Message != "" || nested.Data["msg"] != "done" {
+			t.Errorf("expected JSON logs to be nested, got %q", lines[1])
+		}
+	})
+}
+
+func TestSetLogFormat(t *testing.T) {
+	if err := SetLogFormat("xml"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,167 @@
+package infra
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+)
+
+// Metrics records how long each phase of a run took and the resources used by the containers.
+// A nil *Metrics is valid and records nothing.
+type Metrics struct {
+	mu        sync.Mutex
+	phases    []Phase
+	resources []ResourceUsage
+}
+
+// Phase is a timed step of a run, like pulling an image or running fetch_files.
+type Phase struct {
+	Name     string    `json:"name"`
+	Start    time.Time `json:"start"`
+	Duration float64   `json:"duration-seconds"`
+}
+
+// ResourceUsage is the peak CPU and memory of a container, sampled from the Docker stats API.
+type ResourceUsage struct {
+	Container       string  `json:"container"`
+	PeakCPUPercent  float64 `json:"peak-cpu-percent"`
+	PeakMemoryBytes uint64  `json:"peak-memory-bytes"`
+	Samples         int     `json:"samples"`
+}
+
+// Start begins timing a phase, call the returned function when it's done.
+func (m *Metrics) Start(name string) func() {
+	if m == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.phases = append(m.phases, Phase{
+			Name:     name,
+			Start:    start.UTC(),
+			Duration: time.Since(start).Seconds(),
+		})
+	}
+}
+
+// Time runs fn as a phase.
+func (m *Metrics) Time(name string, fn func() error) error {
+	done := m.Start(name)
+	defer done()
+	return fn()
+}
+
+// Phases returns the completed phases in the order they finished.
+func (m *Metrics) Phases() []Phase {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Phase(nil), m.phases...)
+}
+
+// Resources returns the peak resource usage of each sampled container.
+func (m *Metrics) Resources() []ResourceUsage {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]ResourceUsage(nil), m.resources...)
+}
+
+// SampleStats streams Docker stats for the container until the returned function is called,
+// which records the peaks. Errors are ignored since stats are only informational.
+func (m *Metrics) SampleStats(ctx context.Context, cli *client.Client, name, containerID string) func() {
+	if m == nil {
+		return func() {}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	usage := ResourceUsage{Container: name}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		stats, err := cli.ContainerStats(ctx, containerID, true)
+		if err != nil {
+			return
+		}
+		defer stats.Body.Close()
+		_ = sampleStats(stats.Body, &usage)
+	}()
+	return func() {
+		cancel()
+		<-done
+		if usage.Samples == 0 {
+			return
+		}
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.resources = append(m.resources, usage)
+	}
+}
+
+// sampleStats decodes the stream of stats and keeps the peaks in usage.
+func sampleStats(r io.Reader, usage *ResourceUsage) error {
+	decoder := json.NewDecoder(r)
+	for {
+		var stats container.StatsResponse
+		if err := decoder.Decode(&stats); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		usage.Samples++
+		usage.PeakCPUPercent = max(usage.PeakCPUPercent, cpuPercent(&stats))
+		usage.PeakMemoryBytes = max(usage.PeakMemoryBytes, memoryUsage(&stats))
+	}
+}
+
+// cpuPercent is calculated the same way as `docker stats`, where 100% is one CPU.
+func cpuPercent(stats *container.StatsResponse) float64 {
+	cpuDelta := float64(stats.CPUStats.CPUUsage.TotalUsage) - float64(stats.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(stats.CPUStats.SystemUsage) - float64(stats.PreCPUStats.SystemUsage)
+	cpus := float64(stats.CPUStats.OnlineCPUs)
+	if cpus == 0 {
+		cpus = float64(len(stats.CPUStats.CPUUsage.PercpuUsage))
+	}
+	if cpuDelta <= 0 || systemDelta <= 0 {
+		return 0
+	}
+	return cpuDelta / systemDelta * cpus * 100
+}
+
+// memoryUsage excludes the page cache, the same as `docker stats`.
+func memoryUsage(stats *container.StatsResponse) uint64 {
+	usage := stats.MemoryStats.Usage
+	for _, key := range []string{"inactive_file", "total_inactive_file"} {
+		if cache, ok := stats.MemoryStats.Stats[key]; ok && cache < usage {
+			return usage - cache
+		}
+	}
+	return usage
+}
+
+// formatBytes renders a byte count for humans, e.g. 1.5 GiB.
+func formatBytes(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := uint64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,63 @@
+package infra
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_sampleStats(t *testing.T) {
+	stream := `{"cpu_stats":{"cpu_usage":{"total_usage":200},"system_cpu_usage":1000,"online_cpus":2},"precpu_stats":{"cpu_usage":{"total_usage":100},"system_cpu_usage":500},"memory_stats":{"usage":4096,"stats":{"inactive_file":1024}}}
+{"cpu_stats":{"cpu_usage":{"total_usage":400},"system_cpu_usage":1500,"online_cpus":2},"precpu_stats":{"cpu_usage":{"total_usage":200},"system_cpu_usage":1000},"memory_stats":{"usage":2048}}
+`
+	usage := ResourceUsage{Container: "updater"}
+	if err := sampleStats(strings.NewReader(stream), &usage); err != nil {
+		t.Fatal(err)
+	}
+	if usage.Samples != 2 {
+		t.Errorf("expected 2 samples, got %d", usage.Samples)
+	}
+	// the second sample used 200 of 500 system ticks across 2 CPUs
+	if usage.PeakCPUPercent != 80 {
+		t.Errorf("expected peak CPU of 80%%, got %v", usage.PeakCPUPercent)
+	}
+	// the first sample used 4096 bytes, but 1024 of them were page cache
+	if usage.PeakMemoryBytes != 3072 {
+		t.Errorf("expected peak memory of 3072, got %v", usage.PeakMemoryBytes)
+	}
+}
+
+func Test_formatBytes(t *testing.T) {
+	tests := []struct {
+		bytes    uint64
+		expected string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1536, "1.5 KiB"},
+		{3 * 1024 * 1024 * 1024, "3.0 GiB"},
+	}
+	for _, tt := range tests {
+		if actual := formatBytes(tt.bytes); actual != tt.expected {
+			t.Errorf("formatBytes(%d) = %q, expected %q", tt.bytes, actual, tt.expected)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	t.Run("records phases in order", func(t *testing.T) {
+		m := &Metrics{}
+		_ = m.Time("fetch_files", func() error { return nil })
+		m.Start("update_files")()
+		phases := m.Phases()
+		if len(phases) != 2 || phases[0].Name != "fetch_files" || phases[1].Name != "update_files" {
+			t.Errorf("unexpected phases %+v", phases)
+		}
+	})
+	t.Run("nil records nothing", func(t *testing.T) {
+		var m *Metrics
+		m.Start("fetch_files")()
+		if m.Phases() != nil || m.Resources() != nil {
+			t.Error("expected a nil Metrics to record nothing")
+		}
+	})
+}
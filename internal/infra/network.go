@@ -14,9 +14,14 @@ type Networks struct {
 	cli            *client.Client
 	noInternetName string
 	internetName   string
+	// noInternetGateway is the host's address on the no-internet network
+	noInternetGateway string
 }
 
 func NewNetworks(ctx context.Context, cli *client.Client) (*Networks, error) {
+	ctx, span := tracer.Start(ctx, "create networks")
+	defer span.End()
+
 	const bridge = "bridge"
 
 	noInternetName := namesgenerator.GetRandomName(1)
@@ -36,12 +41,25 @@ func NewNetworks(ctx context.Context, cli *client.Client) (*Networks, error) {
 		return nil, fmt.Errorf("failed to create internet network: %w", err)
 	}
 
+	info, err := cli.NetworkInspect(ctx, noInternet.ID, network.InspectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect no-internet network: %w", err)
+	}
+	var gateway string
+	for _, cfg := range info.IPAM.Config {
+		if cfg.Gateway != "" {
+			gateway = cfg.Gateway
+			break
+		}
+	}
+
 	return &Networks{
-		cli:            cli,
-		NoInternet:     noInternet,
-		Internet:       internet,
-		noInternetName: noInternetName,
-		internetName:   internetName,
+		cli:               cli,
+		NoInternet:        noInternet,
+		Internet:          internet,
+		noInternetName:    noInternetName,
+		internetName:      internetName,
+		noInternetGateway: gateway,
 	}, nil
 }
 
@@ -3,7 +3,6 @@ package infra
 import (
 	"context"
 	"fmt"
-	"io"
 	"log"
 	"os"
 	"path"
@@ -13,7 +12,6 @@ import (
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
-	"github.com/goware/prefixer"
 	"github.com/moby/moby/pkg/stdcopy"
 )
 
@@ -32,10 +30,15 @@ type Collector struct {
 
 // NewCollector starts the OpenTelemetry collector container.
 func NewCollector(ctx context.Context, cli *client.Client, net *Networks, params *RunParams, proxy *Proxy) (*Collector, error) {
+	ctx, span := tracer.Start(ctx, "start collector")
+	defer span.End()
+
 	hostCfg := &container.HostConfig{
 		AutoRemove: false,
 	}
 
+	// the collector is only on the network without internet access, like the updater, so it can't be used
+	// to get around the proxy. The CLI sends its own spans from the host instead.
 	containerCfg := &container.Config{
 		Image: params.CollectorImage,
 		Env: []string{
@@ -69,6 +72,17 @@ func NewCollector(ctx context.Context, cli *client.Client, net *Networks, params
 			ReadOnly: true,
 		})
 	}
+	if params.TelemetryDir != "" {
+		hostCfg.Mounts = append(hostCfg.Mounts, mount.Mount{
+			Type:   mount.TypeBind,
+			Source: params.TelemetryDir,
+			Target: telemetryMountPath,
+		})
+		// run as the current user so the files can be written to, and read afterwards
+		if uid, gid := os.Getuid(), os.Getgid(); uid >= 0 && gid >= 0 {
+			containerCfg.User = fmt.Sprintf("%d:%d", uid, gid)
+		}
+	}
 
 	collectorContainer, err := cli.ContainerCreate(ctx, containerCfg, hostCfg, netCfg, nil, "")
 	if err != nil {
@@ -116,11 +130,11 @@ func (c *Collector) TailLogs(ctx context.Context, cli *client.Client) {
 		return
 	}
 
-	r, w := io.Pipe()
-	go func() {
-		_, _ = io.Copy(os.Stderr, prefixer.New(r, "   otel | "))
-	}()
-	_, _ = stdcopy.StdCopy(w, w, out)
+	stdout := LogWriter(LogSourceOTel, LogStreamStdout)
+	stderr := LogWriter(LogSourceOTel, LogStreamStderr)
+	_, _ = stdcopy.StdCopy(stdout, stderr, out)
+	_ = stdout.Close()
+	_ = stderr.Close()
 }
 
 // Close stops and removes the container.
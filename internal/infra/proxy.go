@@ -4,38 +4,55 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"os"
-	"path"
 	"path/filepath"
+	"strconv"
 
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
-	"github.com/goware/prefixer"
+	"github.com/docker/go-connections/nat"
 	"github.com/moby/moby/pkg/namesgenerator"
 	"github.com/moby/moby/pkg/stdcopy"
 )
 
-const proxyCertPath = "/usr/local/share/ca-certificates/custom-ca-cert.crt"
-
 // ProxyImageName is the default Docker image used by the proxy
 const ProxyImageName = "ghcr.io/dependabot/proxy:latest"
 
+// proxyPort is where the proxy listens, it's published on localhost when the CLI makes requests through it.
+const proxyPort nat.Port = "1080/tcp"
+
 type Proxy struct {
 	cli         *client.Client
 	containerID string
 	url         string
+	hostURL     string
 	ca          CertificateAuthority
 }
 
 func NewProxy(ctx context.Context, cli *client.Client, params *RunParams, nets *Networks) (*Proxy, error) {
-	// Generate secrets:
-	ca, err := GenerateCertificateAuthority()
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate cert: %w", err)
+	ctx, span := tracer.Start(ctx, "start proxy")
+	defer span.End()
+
+	// Get the CA the proxy intercepts HTTPS with, the recorder already has it:
+	var ca CertificateAuthority
+	var err error
+	if params.recorder != nil {
+		ca = params.recorder.ca
+	} else if ca, err = certificateAuthority(params); err != nil {
+		return nil, fmt.Errorf("failed to get the proxy's CA: %w", err)
+	}
+
+	// a replay is offline, so the proxy reaches the host over the no-internet network instead of the internet
+	offline := params.ReplayPath != "" && nets != nil
+	hostGateway := "host-gateway"
+	if offline {
+		if nets.noInternetGateway == "" {
+			return nil, fmt.Errorf("the no-internet network has no gateway to reach the host through")
+		}
+		hostGateway = nets.noInternetGateway
 	}
 
 	// Generate and write configuration to disk:
@@ -46,26 +63,12 @@ func NewProxy(ctx context.Context, cli *client.Client, params *RunParams, nets *
 
 	hostCfg := &container.HostConfig{
 		ExtraHosts: []string{
-			"host.docker.internal:host-gateway",
+			"host.docker.internal:" + hostGateway,
 		},
 	}
 	hostCfg.ExtraHosts = append(hostCfg.ExtraHosts, params.ExtraHosts...)
-	if params.ProxyCertPath != "" {
-		if !path.IsAbs(params.ProxyCertPath) {
-			// needs to be absolute, assume it is relative to the working directory
-			var dir string
-			dir, err = os.Getwd()
-			if err != nil {
-				return nil, fmt.Errorf("couldn't get working directory: %w", err)
-			}
-			params.ProxyCertPath = path.Join(dir, params.ProxyCertPath)
-		}
-		hostCfg.Mounts = append(hostCfg.Mounts, mount.Mount{
-			Type:     mount.TypeBind,
-			Source:   params.ProxyCertPath,
-			Target:   proxyCertPath,
-			ReadOnly: true,
-		})
+	if params.publishProxy {
+		hostCfg.PortBindings = nat.PortMap{proxyPort: {{HostIP: "127.0.0.1"}}}
 	}
 	hostCfg.ExtraHosts = append(hostCfg.ExtraHosts, params.ExtraHosts...)
 	if params.CacheDir != "" {
@@ -77,14 +80,22 @@ func NewProxy(ctx context.Context, cli *client.Client, params *RunParams, nets *
 			Target: "/cache",
 		})
 	}
+	httpProxy, httpsProxy, noProxy := os.Getenv("HTTP_PROXY"), os.Getenv("HTTPS_PROXY"), os.Getenv("NO_PROXY")
+	if params.recorder != nil {
+		httpProxy, noProxy = params.recorder.upstream(params.ApiUrl)
+		httpsProxy = httpProxy
+	}
+	// Responses served from the proxy's cache never reach the recorder,
+	// so the cache is off while a HAR archive is written.
+	proxyCache := params.HARPath == ""
 	config := &container.Config{
 		Image: params.ProxyImage,
 		Env: []string{
-			"HTTP_PROXY=" + os.Getenv("HTTP_PROXY"),
-			"HTTPS_PROXY=" + os.Getenv("HTTPS_PROXY"),
-			"NO_PROXY=" + os.Getenv("NO_PROXY"),
+			"HTTP_PROXY=" + httpProxy,
+			"HTTPS_PROXY=" + httpsProxy,
+			"NO_PROXY=" + noProxy,
 			"JOB_ID=" + jobID,
-			"PROXY_CACHE=true",
+			"PROXY_CACHE=" + strconv.FormatBool(proxyCache),
 			"LOG_RESPONSE_BODY_ON_AUTH_FAILURE=true",
 			"ACTIONS_ID_TOKEN_REQUEST_TOKEN=" + os.Getenv("ACTIONS_ID_TOKEN_REQUEST_TOKEN"),
 			"ACTIONS_ID_TOKEN_REQUEST_URL=" + os.Getenv("ACTIONS_ID_TOKEN_REQUEST_URL"),
@@ -93,6 +104,9 @@ func NewProxy(ctx context.Context, cli *client.Client, params *RunParams, nets *
 			"sh", "-c", "update-ca-certificates && /dependabot-proxy",
 		},
 	}
+	if params.publishProxy {
+		config.ExposedPorts = nat.PortSet{proxyPort: {}}
+	}
 	hostName := namesgenerator.GetRandomName(1)
 	proxyContainer, err := cli.ContainerCreate(ctx, config, hostCfg, nil, nil, hostName)
 	if err != nil {
@@ -110,15 +124,37 @@ func NewProxy(ctx context.Context, cli *client.Client, params *RunParams, nets *
 		return nil, fmt.Errorf("failed to connect to network: %w", err)
 	}
 
+	// the proxy's entrypoint runs update-ca-certificates, so it will trust these
+	certs, err := readCertificates(params.ProxyCertPaths)
+	if err == nil {
+		err = putCertificates(ctx, cli, proxyContainer.ID, "custom-ca-cert", certs)
+	}
+	if err != nil {
+		_ = proxy.Close()
+		return nil, err
+	}
+	// likewise, it will trust its own CA, so the recorder can intercept HTTPS with it
+	if params.recorder != nil {
+		if t, err := tarball(proxyCACertPath, ca.Cert); err != nil {
+			_ = proxy.Close()
+			return nil, fmt.Errorf("failed to create proxy CA cert tarball: %w", err)
+		} else if err = cli.CopyToContainer(ctx, proxyContainer.ID, "/", t, container.CopyToContainerOptions{}); err != nil {
+			_ = proxy.Close()
+			return nil, fmt.Errorf("failed to copy proxy CA cert to container: %w", err)
+		}
+	}
+
 	// nil check since tests don't always need networks
 	if nets != nil {
 		if err = cli.NetworkConnect(ctx, nets.NoInternet.ID, proxyContainer.ID, &network.EndpointSettings{}); err != nil {
 			_ = proxy.Close()
 			return nil, fmt.Errorf("failed to connect to internal network: %w", err)
 		}
-		if err = cli.NetworkConnect(ctx, nets.Internet.ID, proxyContainer.ID, &network.EndpointSettings{}); err != nil {
-			_ = proxy.Close()
-			return nil, fmt.Errorf("failed to connect to external network: %w", err)
+		if !offline {
+			if err = cli.NetworkConnect(ctx, nets.Internet.ID, proxyContainer.ID, &network.EndpointSettings{}); err != nil {
+				_ = proxy.Close()
+				return nil, fmt.Errorf("failed to connect to external network: %w", err)
+			}
 		}
 	}
 
@@ -137,6 +173,9 @@ func NewProxy(ctx context.Context, cli *client.Client, params *RunParams, nets *
 		// This should only happen during testing, adding a warning in case
 		log.Println("Warning: no-internet network not found")
 	}
+	if bindings := containerInfo.NetworkSettings.Ports[proxyPort]; len(bindings) > 0 {
+		proxy.hostURL = fmt.Sprintf("http://127.0.0.1:%s", bindings[0].HostPort)
+	}
 
 	return proxy, nil
 }
@@ -166,11 +205,11 @@ func (p *Proxy) TailLogs(ctx context.Context, cli *client.Client) {
 		return
 	}
 
-	r, w := io.Pipe()
-	go func() {
-		_, _ = io.Copy(os.Stderr, prefixer.New(r, "  proxy | "))
-	}()
-	_, _ = stdcopy.StdCopy(w, w, out)
+	stdout := LogWriter(LogSourceProxy, LogStreamStdout)
+	stderr := LogWriter(LogSourceProxy, LogStreamStderr)
+	_, _ = stdcopy.StdCopy(stdout, stderr, out)
+	_ = stdout.Close()
+	_ = stderr.Close()
 }
 
 func (p *Proxy) Close() (err error) {
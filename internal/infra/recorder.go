@@ -0,0 +1,407 @@
+package infra
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/dependabot/cli/internal/model"
+)
+
+// proxyCACertPath is where the proxy's own CA is installed in it, so it trusts the recorder, which
+// intercepts HTTPS with the same CA.
+const proxyCACertPath = "/usr/local/share/ca-certificates/dependabot-cli-proxy-ca.crt"
+
+// recorderUser is the user name in the recorder's upstream URL, the token is the password.
+const recorderUser = "dependabot"
+
+// Recorder is an HTTP proxy the Dependabot proxy sends its requests through, so the traffic to
+// registries can be recorded to a cassette or replayed from one, captured as a HAR archive, or
+// restricted to allowed hosts. HTTPS is intercepted with the proxy's CA.
+type Recorder struct {
+	ca        CertificateAuthority
+	caCert    *x509.Certificate
+	caKey     crypto.Signer
+	leafKey   *ecdsa.PrivateKey
+	server    *http.Server
+	port      int
+	token     string
+	secrets   []string
+	transport http.RoundTripper
+
+	mu       sync.Mutex
+	certs    map[string]*tls.Certificate
+	cassette *Cassette
+	replayer *replayer
+	har      *harCapture
+	policy   *egressPolicy
+}
+
+// RecorderOptions configures what a Recorder does with the exchanges.
+type RecorderOptions struct {
+	// Record keeps the exchanges so they can be saved as a cassette
+	Record bool
+	// Replay serves the exchanges from a cassette, nothing is sent to the internet
+	Replay *Cassette
+	// HAR captures the exchanges as a HAR archive
+	HAR bool
+	// HARBodyLimit is the most bytes of each body kept in the HAR archive
+	HARBodyLimit int
+	// Creds are the credentials the proxy injects, which are redacted from the cassette and HAR archive
+	Creds []model.Credential
+	// AllowedHosts restricts the hosts that can be reached, when it's set
+	AllowedHosts []string
+	// CA is the proxy's CA, which the recorder intercepts HTTPS with
+	CA CertificateAuthority
+}
+
+// NewRecorder creates a recorder, which serves requests once it's listening. Unless a cassette is replayed,
+// the exchanges are forwarded to the internet.
+func NewRecorder(opts RecorderOptions) (*Recorder, error) {
+	caCert, caKey, err := parseCertificateAuthority(opts.CA)
+	if err != nil {
+		return nil, err
+	}
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate recorder key: %w", err)
+	}
+
+	// the recorder can be reachable from the network, so only the proxy knows the token to use it
+	token := make([]byte, 16)
+	if _, err = rand.Read(token); err != nil {
+		return nil, fmt.Errorf("failed to generate recorder token: %w", err)
+	}
+
+	r := &Recorder{
+		ca:        opts.CA,
+		caCert:    caCert,
+		caKey:     caKey,
+		leafKey:   leafKey,
+		token:     hex.EncodeToString(token),
+		secrets:   credentialSecrets(opts.Creds),
+		transport: &http.Transport{Proxy: http.ProxyFromEnvironment},
+		certs:     map[string]*tls.Certificate{},
+	}
+	if opts.Record {
+		r.cassette = &Cassette{}
+	}
+	if opts.Replay != nil {
+		r.replayer = newReplayer(opts.Replay)
+	}
+	if opts.HAR {
+		r.har = newHARCapture(opts.HARBodyLimit, opts.Creds)
+	}
+	if opts.AllowedHosts != nil {
+		r.policy = newEgressPolicy(opts.AllowedHosts)
+	}
+	r.server = &http.Server{
+		Handler:           r,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return r, nil
+}
+
+// recorderHost returns the address the recorder listens on. The proxy reaches it through host.docker.internal,
+// like the fake API, unless the run is offline and the host is the no-internet network's gateway.
+func recorderHost(gateway string) string {
+	switch {
+	case gateway != "":
+		return gateway
+	case runtime.GOOS == "linux":
+		return "0.0.0.0"
+	default:
+		return "127.0.0.1"
+	}
+}
+
+// Listen starts serving requests on the host, on a port of its own.
+func (r *Recorder) Listen(host string) error {
+	l, err := (&net.ListenConfig{}).Listen(context.Background(), "tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		return fmt.Errorf("failed to start recorder: %w", err)
+	}
+	r.port = l.Addr().(*net.TCPAddr).Port
+	go func() {
+		if err := r.server.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Println("recorder failed:", err)
+		}
+	}()
+	return nil
+}
+
+// Port returns the port the recorder is listening on.
+func (r *Recorder) Port() int {
+	return r.port
+}
+
+// Cassette returns the exchanges recorded so far.
+func (r *Recorder) Cassette() *Cassette {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cassette == nil {
+		return &Cassette{}
+	}
+	return &Cassette{Interactions: append([]Interaction(nil), r.cassette.Interactions...)}
+}
+
+// HAR returns the archive of the exchanges captured so far.
+func (r *Recorder) HAR() *HAR {
+	if r.har == nil {
+		return (&harCapture{}).har()
+	}
+	return r.har.har()
+}
+
+// Misses returns the requests that weren't in the cassette being replayed.
+func (r *Recorder) Misses() []string {
+	if r.replayer == nil {
+		return nil
+	}
+	r.replayer.mu.Lock()
+	defer r.replayer.mu.Unlock()
+	return append([]string(nil), r.replayer.misses...)
+}
+
+// Blocked returns the hosts that were blocked because they aren't allowed. It's nil-safe since there's
+// only a recorder when something needs it.
+func (r *Recorder) Blocked() []BlockedHost {
+	if r == nil {
+		return nil
+	}
+	return r.policy.blockedHosts()
+}
+
+// Close stops the recorder.
+func (r *Recorder) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_ = r.server.Shutdown(ctx)
+}
+
+// ServeHTTP handles plain HTTP requests, and CONNECT requests by intercepting the TLS connection.
+func (r *Recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !r.authorized(req) {
+		w.Header().Set("Proxy-Authenticate", `Basic realm="dependabot-cli"`)
+		http.Error(w, "proxy authentication required", http.StatusProxyAuthRequired)
+		return
+	}
+	if !r.policy.allow(req.URL.Hostname()) {
+		http.Error(w, "the host isn't allowed by --allow-host", http.StatusForbidden)
+		return
+	}
+	if req.Method != http.MethodConnect {
+		resp := r.exchange(req, req.URL.String())
+		defer resp.Body.Close()
+		for k, v := range resp.Header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(resp.StatusCode)
+		_, _ = io.Copy(w, resp.Body)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, _, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	if _, err = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")); err != nil {
+		return
+	}
+
+	host := req.URL.Hostname()
+	tlsConn := tls.Server(conn, &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if hello.ServerName != "" {
+				return r.certificate(hello.ServerName)
+			}
+			return r.certificate(host)
+		},
+	})
+	defer tlsConn.Close()
+
+	reader := bufio.NewReader(tlsConn)
+	for {
+		inner, err := http.ReadRequest(reader)
+		if err != nil {
+			return
+		}
+		url := "https://" + req.URL.Host + inner.URL.RequestURI()
+		resp := r.exchange(inner, url)
+		err = resp.Write(tlsConn)
+		resp.Body.Close()
+		if err != nil || inner.Close {
+			return
+		}
+	}
+}
+
+// authorized reports whether the request has the recorder's token, which the proxy sends since it's in its upstream URL.
+func (r *Recorder) authorized(req *http.Request) bool {
+	expected := "Basic " + base64.StdEncoding.EncodeToString([]byte(recorderUser+":"+r.token))
+	return subtle.ConstantTimeCompare([]byte(req.Header.Get("Proxy-Authorization")), []byte(expected)) == 1
+}
+
+// exchange records or replays a single request.
+func (r *Recorder) exchange(req *http.Request, url string) *http.Response {
+	defer req.Body.Close()
+	started := time.Now()
+	reqBody, err := io.ReadAll(req.Body)
+	if err != nil {
+		return textResponse(req, http.StatusBadGateway, err.Error())
+	}
+	status, header, body := r.roundTrip(req, url, reqBody)
+	r.har.add(req, url, reqBody, status, header, body, started, time.Since(started))
+	return newResponse(req, status, header, body)
+}
+
+// roundTrip returns the response to a request, from the cassette being replayed or the internet.
+func (r *Recorder) roundTrip(req *http.Request, url string, reqBody []byte) (int, http.Header, []byte) {
+	if r.replayer != nil {
+		recorded, ok := r.replayer.next(req.Method, url)
+		if !ok {
+			log.Printf("replay: no recorded response for %s %s", req.Method, url)
+			return errorResult("no recorded response in the cassette")
+		}
+		body, err := recorded.body()
+		if err != nil {
+			return errorResult("invalid recorded response")
+		}
+		return recorded.Status, http.Header(recorded.Headers).Clone(), body
+	}
+
+	out, err := http.NewRequestWithContext(req.Context(), req.Method, url, bytes.NewReader(reqBody))
+	if err != nil {
+		return errorResult(err.Error())
+	}
+	out.Header = req.Header.Clone()
+	// let the transport negotiate compression, so the recorded bodies are readable
+	for _, h := range []string{"Accept-Encoding", "Connection", "Proxy-Connection", "Proxy-Authorization"} {
+		out.Header.Del(h)
+	}
+
+	resp, err := r.transport.RoundTrip(out)
+	if err != nil {
+		return errorResult(err.Error())
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return errorResult(err.Error())
+	}
+
+	if r.cassette != nil {
+		recorded := newRecordedResponse(resp.StatusCode, resp.Header, body, r.secrets)
+		r.mu.Lock()
+		r.cassette.Interactions = append(r.cassette.Interactions, Interaction{
+			Request:  RecordedRequest{Method: req.Method, URL: url},
+			Response: recorded,
+		})
+		r.mu.Unlock()
+	}
+
+	header := resp.Header.Clone()
+	for _, h := range hopHeaders {
+		header.Del(h)
+	}
+	return resp.StatusCode, header, body
+}
+
+// hopHeaders describe the connection to the recorder rather than the response, so they aren't passed on.
+var hopHeaders = []string{"Connection", "Content-Length", "Keep-Alive", "Transfer-Encoding"}
+
+func errorResult(text string) (int, http.Header, []byte) {
+	return http.StatusBadGateway, http.Header{"Content-Type": {"text/plain; charset=utf-8"}}, []byte(text + "\n")
+}
+
+func newResponse(req *http.Request, status int, header http.Header, body []byte) *http.Response {
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		StatusCode:    status,
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+func textResponse(req *http.Request, status int, text string) *http.Response {
+	return newResponse(req, status, http.Header{"Content-Type": {"text/plain; charset=utf-8"}}, []byte(text))
+}
+
+// certificate returns a certificate for the host signed by the proxy's CA.
+func (r *Recorder) certificate(host string) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if cert, ok := r.certs[host]; ok {
+		return cert, nil
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, r.caCert, r.leafKey.Public(), r.caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate for %s: %w", host, err)
+	}
+	cert := &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: r.leafKey}
+	r.certs[host] = cert
+	return cert, nil
+}
+
+// upstream returns the proxy settings that send the Dependabot proxy's requests through the recorder.
+// Only the fake API's port is excluded, so nothing else on the host bypasses the allowed hosts. The recorder
+// applies the host's proxy settings itself.
+func (r *Recorder) upstream(apiURL string) (proxyURL, noProxy string) {
+	if u, err := url.Parse(apiURL); err == nil {
+		noProxy = u.Host
+	}
+	return fmt.Sprintf("http://%s:%s@host.docker.internal:%d", recorderUser, r.token, r.port), noProxy
+}
@@ -5,13 +5,18 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"regexp"
+	"runtime"
+	"slices"
+	"sort"
 	"strings"
 	"syscall"
 	"time"
@@ -27,15 +32,18 @@ import (
 	"github.com/hexops/gotextdiff/span"
 	archive "github.com/moby/go-archive"
 	"github.com/moby/moby/api/types/registry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"gopkg.in/yaml.v3"
 )
 
-var runCmds = map[model.RunCommand]string{
-	model.VersionCommand:     "bin/run fetch_files && bin/run update_files",
-	model.UpdateFilesCommand: "bin/run fetch_files && bin/run update_files",
-	model.RecreateCommand:    "bin/run fetch_files && bin/run update_files",
-	model.SecurityCommand:    "bin/run fetch_files && bin/run update_files",
-	model.UpdateGraphCommand: "bin/run fetch_files && bin/run update_graph",
+// runCmds are the bin/run steps for each command, each is run in a separate exec so it can be timed.
+var runCmds = map[model.RunCommand][]string{
+	model.VersionCommand:     {"fetch_files", "update_files"},
+	model.UpdateFilesCommand: {"fetch_files", "update_files"},
+	model.RecreateCommand:    {"fetch_files", "update_files"},
+	model.SecurityCommand:    {"fetch_files", "update_files"},
+	model.UpdateGraphCommand: {"fetch_files", "update_graph"},
 }
 
 type RunParams struct {
@@ -45,6 +53,15 @@ type RunParams struct {
 	Job *model.Job
 	// expectations asserted at the end of a test
 	Expected []model.Output
+	// ExpectedOrder and ExpectedMode are how the outputs are matched to the expectations, see model.SmokeTest
+	ExpectedOrder string
+	ExpectedMode  string
+	// FullDiff prints a diff of the whole smoke test against the output when expectations fail,
+	// instead of the fields that differ
+	FullDiff bool
+	// UpdateExpectations rewrites the output section of the smoke test in InputName with the outputs,
+	// instead of failing when they don't meet the expectations
+	UpdateExpectations bool
 	// directory to copy into the updater container as the repo
 	LocalDir string
 	// credentials passed to the proxy
@@ -53,8 +70,8 @@ type RunParams struct {
 	CacheDir string
 	// write output to a file
 	Output string
-	// ProxyCertPath is the path to a cert for the proxy to trust
-	ProxyCertPath string
+	// ProxyCertPaths are certificates for the proxy to trust: PEM files, bundles, or directories of them
+	ProxyCertPaths []string
 	// attempt to pull images if they aren't local?
 	PullImages bool
 	// run an interactive shell?
@@ -85,6 +102,68 @@ type RunParams struct {
 	ApiUrl    string
 	// UpdaterEnvironmentVariables are additional environment variables to set in the update container
 	UpdaterEnvironmentVariables []string
+	// SummaryWriter is where a human-readable summary is written when the run finishes
+	SummaryWriter io.Writer
+	// ArtifactsDir is an optional directory to collect logs, inputs, outputs, and a manifest of the run in
+	ArtifactsDir string
+	// OTLPEndpoint is an optional OTLP/HTTP endpoint to send the CLI's spans to, instead of TelemetryDir
+	OTLPEndpoint string
+	// TelemetryDir is an optional directory the collector writes traces, metrics, and logs to, instead of using CollectorConfigPath
+	TelemetryDir string
+	// KeepOnFailure leaves the containers and networks running when the update fails, so they can be attached to
+	KeepOnFailure bool
+	// Exec is a shell command to run in the updater instead of the job's commands
+	Exec string
+	// ExecFile is a script to copy into the updater and run instead of the job's commands
+	ExecFile string
+	// CorePath is a dependabot-core checkout whose sources are mounted in the updater
+	CorePath string
+	// RecordPath is where to write a cassette of the HTTP exchanges the proxy made
+	RecordPath string
+	// ReplayPath is a cassette to serve the proxy's HTTP exchanges from instead of the internet
+	ReplayPath string
+	// HARPath is where to write a HAR archive of the proxy's HTTP exchanges
+	HARPath string
+	// HARBodyLimit is the most bytes of each request and response body kept in the HAR archive
+	HARBodyLimit int
+	// AllowedHosts restricts the proxy to these hosts, plus the ones the job needs, when it's set
+	AllowedHosts []string
+	// RestrictEgress restricts the proxy to the hosts the job needs, even when there are no AllowedHosts
+	RestrictEgress bool
+	// Preflight checks each credential against its registry before the update
+	Preflight bool
+	// AllowedTokenPermissions are the repository permissions a GitHub token may have, DefaultAllowedTokenPermissions if nil
+	AllowedTokenPermissions []string
+	// CACertPath and CAKeyPath are a CA for the proxy to intercept HTTPS with, instead of generating one
+	CACertPath string
+	CAKeyPath  string
+	// CADir keeps a CA that's generated on first use and reused by later runs
+	CADir string
+	// CAKeyType is the type of key of a generated CA, KeyTypeRSA or KeyTypeECDSA
+	CAKeyType string
+	// CAValidity is how long a generated CA is valid for, two years if it's zero
+	CAValidity time.Duration
+	// UpdaterCertPaths are certificates for the updater to trust, like ProxyCertPaths
+	UpdaterCertPaths []string
+	// APIFaults are failures for the fake API to inject, from the input
+	APIFaults []model.APIFault
+	// APIFaultsPath is a fault profile with more failures for the fake API to inject
+	APIFaultsPath string
+	// StrictAPI makes the fake API answer endpoints it doesn't know with a 501 instead of recording them
+	StrictAPI bool
+	// RecordMetrics keeps the updater's metrics and ecosystem versions, and sends the metrics to OTLPEndpoint if it's set
+	RecordMetrics bool
+	// ForwardTo are HTTP endpoints to relay each API call the updater makes to
+	ForwardTo []string
+	// ForwardSecret signs the forwarded bodies with HMAC-SHA256 when it's set
+	ForwardSecret string
+	// ForwardBatch sends the forwarded calls in batches of up to this many, one by one if it's 0 or 1
+	ForwardBatch int
+
+	// publishProxy publishes the proxy's port on localhost, so the CLI can make requests through it
+	publishProxy bool
+	// recorder is the upstream of the proxy when its traffic is recorded, replayed, captured, or restricted
+	recorder *Recorder
 }
 
 var gitShaRegex = regexp.MustCompile(`^[0-9a-f]{40}$`)
@@ -96,6 +175,106 @@ func (p *RunParams) Validate() error {
 	if p.Job.Source.Commit != "" && !gitShaRegex.MatchString(p.Job.Source.Commit) {
 		return fmt.Errorf("commit must be a SHA, or not provided")
 	}
+	if p.Exec != "" && p.ExecFile != "" {
+		return fmt.Errorf("can't use an exec command and an exec file together")
+	}
+	if p.Debug && (p.Exec != "" || p.ExecFile != "") {
+		return fmt.Errorf("can't exec a command in debug mode")
+	}
+	if p.ExecFile != "" {
+		if _, err := os.Stat(p.ExecFile); err != nil {
+			return fmt.Errorf("exec file: %w", err)
+		}
+	}
+	if p.CorePath != "" {
+		if _, err := coreVolumes(p.CorePath, p.Job.PackageManager); err != nil {
+			return err
+		}
+	}
+	if p.RecordPath != "" && p.ReplayPath != "" {
+		return fmt.Errorf("can't record and replay at the same time")
+	}
+	if p.ReplayPath != "" {
+		if _, err := os.Stat(p.ReplayPath); err != nil {
+			return fmt.Errorf("cassette: %w", err)
+		}
+		// the proxy reaches the CLI over the no-internet network's gateway, which is only on the host with Linux
+		if runtime.GOOS != "linux" {
+			return fmt.Errorf("replaying a cassette needs Docker on Linux, so the proxy can run without the internet")
+		}
+		if p.Preflight {
+			return fmt.Errorf("can't check credentials while replaying a cassette, since it runs without the internet")
+		}
+	}
+	for _, host := range p.AllowedHosts {
+		// a wildcard is only allowed as a whole label, so *example.com can't match evilexample.com
+		if host == "" || strings.ContainsAny(host, "/:") || strings.Contains(strings.TrimPrefix(host, "*."), "*") {
+			return fmt.Errorf("invalid allowed host %q, expected a host name like registry.npmjs.org or *.example.com", host)
+		}
+	}
+	if (p.CACertPath == "") != (p.CAKeyPath == "") {
+		return fmt.Errorf("a CA needs both a certificate and a key")
+	}
+	if p.CACertPath != "" && p.CADir != "" {
+		return fmt.Errorf("can't use a CA and a CA directory together")
+	}
+	if p.CACertPath != "" {
+		if _, err := loadCertificateAuthority(p.CACertPath, p.CAKeyPath); err != nil {
+			return err
+		}
+	}
+	if p.ExpectedOrder != "" && p.ExpectedOrder != model.OrderStrict && p.ExpectedOrder != model.OrderAny {
+		return fmt.Errorf("unknown order %q, expected %s or %s", p.ExpectedOrder, model.OrderStrict, model.OrderAny)
+	}
+	if p.ExpectedMode != "" && p.ExpectedMode != model.ModeExact && p.ExpectedMode != model.ModeSubset {
+		return fmt.Errorf("unknown mode %q, expected %s or %s", p.ExpectedMode, model.ModeExact, model.ModeSubset)
+	}
+	if p.UpdateExpectations && p.InputName == "" {
+		return fmt.Errorf("can't update the expectations without a smoke test file")
+	}
+	if p.CAKeyType != "" && p.CAKeyType != KeyTypeRSA && p.CAKeyType != KeyTypeECDSA {
+		return fmt.Errorf("unknown CA key type %q, expected %s or %s", p.CAKeyType, KeyTypeRSA, KeyTypeECDSA)
+	}
+	if p.CAValidity < 0 {
+		return fmt.Errorf("the CA validity can't be negative")
+	}
+	if _, err := readCertificates(p.ProxyCertPaths); err != nil {
+		return fmt.Errorf("proxy certificates: %w", err)
+	}
+	if _, err := readCertificates(p.UpdaterCertPaths); err != nil {
+		return fmt.Errorf("updater certificates: %w", err)
+	}
+	if err := server.ValidateFaults(p.APIFaults); err != nil {
+		return err
+	}
+	if p.APIFaultsPath != "" {
+		if _, err := server.ReadFaults(p.APIFaultsPath); err != nil {
+			return err
+		}
+	}
+	for _, u := range p.ForwardTo {
+		if parsed, err := url.Parse(u); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("invalid forwarding URL %q, expected an http or https URL", u)
+		}
+	}
+	if p.ForwardBatch < 0 {
+		return fmt.Errorf("the forwarding batch size can't be negative")
+	}
+	if p.HARBodyLimit < 0 {
+		return fmt.Errorf("the HAR body limit can't be negative")
+	}
+	if p.CollectorConfigPath != "" && p.TelemetryDir != "" {
+		return fmt.Errorf("can't use a collector config and a telemetry directory together")
+	}
+	// the proxy of a kept run would have no upstream, since the tap stops with the CLI
+	if p.KeepOnFailure && (p.RecordPath != "" || p.ReplayPath != "" || p.HARPath != "" || p.allowedEgress() != nil) {
+		return fmt.Errorf("can't keep the containers with --har, --record, --replay, --allow-host, or --restrict-egress, " +
+			"since the proxy's requests go through the CLI, which stops with the run")
+	}
+	// the collector is only on the network without internet access, where the CLI can't send its own spans
+	if p.CollectorConfigPath != "" && p.OTLPEndpoint == "" {
+		return fmt.Errorf("a collector config needs an OTLP endpoint for the CLI's own spans, use --otlp-endpoint")
+	}
 	// Allows for older smoke tests without the command field to keep working.
 	if p.Job.Command == "" {
 		p.Job.Command = model.UpdateFilesCommand
@@ -103,17 +282,43 @@ func (p *RunParams) Validate() error {
 	return nil
 }
 
-func Run(params RunParams) error {
+func Run(params RunParams) (err error) {
 	if err := params.Validate(); err != nil {
 		return err
 	}
 
-	var ctx context.Context
+	artifacts, err := NewArtifacts(params.ArtifactsDir)
+	if err != nil {
+		return err
+	}
+	metrics := &Metrics{}
+	defer func() {
+		artifacts.RecordMetrics(metrics)
+		artifacts.Close(err)
+	}()
+
+	if err = prepareTelemetryDir(&params); err != nil {
+		return err
+	}
+	if params.CorePath != "" {
+		volumes, err := coreVolumes(params.CorePath, params.Job.PackageManager)
+		if err != nil {
+			return err
+		}
+		params.Volumes = append(params.Volumes, volumes...)
+	}
+
+	ctx, tracing, err := startTracing(context.Background(), params.OTLPEndpoint, params.TelemetryDir)
+	if err != nil {
+		return err
+	}
+	defer func() { tracing.shutdown(err) }()
+
 	var cancel func()
 	if params.Timeout > 0 {
-		ctx, cancel = context.WithTimeout(context.Background(), params.Timeout)
+		ctx, cancel = context.WithTimeout(ctx, params.Timeout)
 	} else {
-		ctx, cancel = context.WithCancel(context.Background())
+		ctx, cancel = context.WithCancel(ctx)
 	}
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
@@ -122,8 +327,27 @@ func Run(params RunParams) error {
 		cancel()
 	}()
 
-	api := server.NewAPI(params.Expected, params.Writer)
+	if params.APIFaultsPath != "" {
+		faults, err := server.ReadFaults(params.APIFaultsPath)
+		if err != nil {
+			return err
+		}
+		params.APIFaults = slices.Concat(params.APIFaults, faults)
+	}
+
+	api := server.NewAPI(params.Expected, artifacts.Writer(artifactEvents, params.Writer))
 	defer api.Stop()
+	api.SetTraceContext(ctx)
+	api.SetFaults(params.APIFaults)
+	api.SetStrict(params.StrictAPI)
+	api.SetExpectationModes(params.ExpectedOrder == model.OrderAny, params.ExpectedMode == model.ModeSubset)
+	api.SetRecordMetrics(params.RecordMetrics)
+	var forwarder *server.Forwarder
+	if len(params.ForwardTo) > 0 {
+		forwarder = server.NewForwarder(params.ForwardTo, params.ForwardSecret, params.ForwardBatch)
+		api.SetForwarder(forwarder)
+		defer forwarder.Close()
+	}
 
 	var outFile *os.File
 	if params.Output != "" {
@@ -138,14 +362,49 @@ func Run(params RunParams) error {
 	}
 
 	expandEnvironmentVariables(api, &params)
-	if err := checkCredAccess(ctx, params.Job, params.Creds); err != nil {
-		return err
+	err = metrics.Time("check credentials", func() error { return checkCredAccess(ctx, params.Job, params.Creds, params.AllowedTokenPermissions) })
+	if err != nil {
+		if errors.Is(err, ErrWriteAccess) {
+			return err
+		}
+		return &infrastructureError{err: err}
+	}
+
+	// created after the credentials are expanded, so the HAR archive can redact them
+	allowedEgress := params.allowedEgress()
+	if params.RecordPath != "" || params.ReplayPath != "" || params.HARPath != "" || allowedEgress != nil {
+		opts := RecorderOptions{
+			Record:       params.RecordPath != "",
+			HAR:          params.HARPath != "",
+			HARBodyLimit: params.HARBodyLimit,
+			Creds:        params.Creds,
+			AllowedHosts: allowedEgress,
+		}
+		if params.ReplayPath != "" {
+			if opts.Replay, err = LoadCassette(params.ReplayPath); err != nil {
+				return err
+			}
+		}
+		// the recorder intercepts HTTPS with the proxy's CA, so the proxy only has to trust its own
+		if opts.CA, err = certificateAuthority(&params); err != nil {
+			return fmt.Errorf("failed to get the proxy's CA: %w", err)
+		}
+		if params.recorder, err = NewRecorder(opts); err != nil {
+			return err
+		}
+		defer params.recorder.Close()
 	}
 
 	if err := setImageNames(&params); err != nil {
 		return err
 	}
 
+	if params.Preflight {
+		if err = metrics.Time("preflight", func() error { return preflight(ctx, params) }); err != nil {
+			return err
+		}
+	}
+
 	if params.ApiUrl == "" {
 		params.ApiUrl = fmt.Sprintf("http://host.docker.internal:%v", api.Port())
 	}
@@ -153,29 +412,120 @@ func Run(params RunParams) error {
 	// run the containers, but don't return the error until AFTER the output is generated.
 	// this ensures that the output is always written in the smoke test where there are multiple outputs,
 	// some that succeed and some that fail; we still want to see the output of the successful ones.
-	runContainersErr := runContainers(ctx, params)
+	runContainersErr := classifyRunError(runContainers(ctx, params, artifacts, metrics, tracing))
+
+	// the updater is done, so every event has been received
+	forwarder.Close()
+
+	if params.RecordPath != "" {
+		if err := params.recorder.Cassette().Save(params.RecordPath); err != nil {
+			return err
+		}
+	}
+	if params.HARPath != "" {
+		if err := params.recorder.HAR().Save(params.HARPath); err != nil {
+			return err
+		}
+	}
 
 	api.Complete()
 
+	if w := artifacts.Writer(artifactSummary, params.SummaryWriter); w != nil {
+		summary := NewSummary(api.Actual.Output, params.Job)
+		summary.Phases = metrics.Phases()
+		summary.Resources = metrics.Resources()
+		summary.Blocked = params.recorder.Blocked()
+		summary.Metrics = AggregateMetrics(api.Actual.Metrics)
+		summary.Ecosystems = Ecosystems(api.Actual.Metrics)
+		if err := summary.Write(w); err != nil {
+			log.Println("failed to write summary:", err)
+		}
+	}
+
+	if params.RecordMetrics && params.OTLPEndpoint != "" {
+		// not the run's context, since the metrics are wanted even if it timed out
+		if err := exportMetrics(context.Background(), params.OTLPEndpoint, AggregateMetrics(api.Actual.Metrics)); err != nil {
+			log.Println("failed to export the updater's metrics:", err)
+		}
+	}
+
 	// write the output to a file
 	output, err := generateOutput(params, api, outFile)
 	if err != nil {
 		return err
 	}
+	artifacts.WriteFile(artifactOutput, output)
+
+	if params.UpdateExpectations {
+		// an update that failed may not have sent every output, so the expectations are kept
+		if runContainersErr != nil {
+			return runContainersErr
+		}
+		return updateSmokeTest(params, api.Actual.Output)
+	}
 
 	if len(api.Errors) > 0 {
-		return diff(params, outFile, output)
+		if params.FullDiff {
+			return diff(params, outFile, output)
+		}
+		w := LogWriter(LogSourceCLI, LogStreamStderr)
+		reportFailures(w, api.Errors)
+		_ = w.Close()
+		return fmt.Errorf("update failed expectations")
+	}
+
+	// the updater may have handled the failed requests, so they'd go unnoticed otherwise
+	if params.ReplayPath != "" {
+		if misses := params.recorder.Misses(); len(misses) > 0 {
+			return fmt.Errorf("%d requests weren't in the cassette, record it again", len(misses))
+		}
+	}
+
+	// A handled error reported by the updater is more specific than its exit code, so prefer it.
+	// With --exec the command's exit code is the result, whatever the commands it ran reported.
+	var exitErr *UpdaterExitError
+	isExec := params.Exec != "" || params.ExecFile != ""
+	if params.Expected == nil && !isExec && (runContainersErr == nil || errors.As(runContainersErr, &exitErr)) {
+		if jobErr := findJobError(api.Actual.Output); jobErr != nil {
+			return jobErr
+		}
 	}
 
 	return runContainersErr
 }
 
+// classifyRunError marks errors from running the containers as infrastructure failures,
+// unless they were caused by the updater or the run being cancelled.
+func classifyRunError(err error) error {
+	var exitErr *UpdaterExitError
+	var execErr *ExecExitError
+	if err == nil || errors.As(err, &exitErr) || errors.As(err, &execErr) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return err
+	}
+	return &infrastructureError{err: err}
+}
+
+// findJobError returns the first error the updater recorded with the API, if any.
+func findJobError(outputs []model.Output) *JobError {
+	for _, out := range outputs {
+		if data, ok := out.Expect.Data.(model.RecordUpdateJobError); ok {
+			return &JobError{ErrorType: data.ErrorType, ErrorDetails: data.ErrorDetails}
+		}
+	}
+	return nil
+}
+
 func generateOutput(params RunParams, api *server.API, outFile *os.File) ([]byte, error) {
 	if params.Job.Source.Commit == "" {
 		// store the SHA we worked with for reproducible tests
 		params.Job.Source.Commit = api.Actual.Input.Job.Source.Commit
 	}
 	api.Actual.Input.Job = *params.Job
+	api.Actual.Input.AllowedHosts = params.AllowedHosts
+	api.Actual.Input.RestrictEgress = params.RestrictEgress
+	api.Actual.Input.APIFaults = params.APIFaults
+	api.Actual.Order = params.ExpectedOrder
+	api.Actual.Mode = params.ExpectedMode
 
 	// ignore conditions help make tests reproducible, so they are generated if there aren't any yet
 	if len(api.Actual.Input.Job.IgnoreConditions) == 0 {
@@ -215,7 +565,9 @@ func diff(params RunParams, outFile *os.File, output []byte) error {
 	}
 	aString := string(params.InputRaw)
 	edits := myers.ComputeEdits(span.URIFromPath(inName), aString, string(output))
-	_, _ = fmt.Fprintln(os.Stderr, gotextdiff.ToUnified(inName, outName, aString, edits))
+	w := LogWriter(LogSourceCLI, LogStreamStderr)
+	_, _ = fmt.Fprintln(w, gotextdiff.ToUnified(inName, outName, aString, edits))
+	_ = w.Close()
 
 	return fmt.Errorf("update failed expectations")
 }
@@ -225,11 +577,28 @@ var (
 	ErrWriteAccess     = fmt.Errorf("for security, credentials used in update are not allowed to have write access to GitHub API")
 )
 
+// DefaultAllowedTokenPermissions are the repository permissions a GitHub token may have when none are configured.
+var DefaultAllowedTokenPermissions = []string{"pull", "triage"}
+
+// scopedTokenPrefixes are GitHub tokens whose access is described by OAuth scopes: classic personal access tokens and OAuth tokens.
+var scopedTokenPrefixes = []string{"ghp_", "gho_"}
+
+// permissionTokenPrefixes are GitHub tokens limited by permissions instead of scopes: fine-grained personal access
+// tokens, and GitHub App installation and user tokens. Their permissions on the job's repository are checked.
+var permissionTokenPrefixes = []string{"github_pat_", "ghs_", "ghu_"}
+
 // checkCredAccess returns an error if any of the tokens in the job definition have write access.
 // Some package managers can execute arbitrary code during an update. The credentials are not accessible to the updater,
 // but the proxy injects them in requests, and the updater could execute arbitrary requests. So to be safe, disallow
 // write access on these tokens.
-func checkCredAccess(ctx context.Context, job *model.Job, creds []model.Credential) error {
+func checkCredAccess(ctx context.Context, job *model.Job, creds []model.Credential, allowedPermissions []string) error {
+	if allowedPermissions == nil {
+		allowedPermissions = DefaultAllowedTokenPermissions
+	}
+	apiEndpoint := defaultApiEndpoint
+	if job != nil && job.Source.APIEndpoint != nil && *job.Source.APIEndpoint != "" {
+		apiEndpoint = *job.Source.APIEndpoint
+	}
 	for _, cred := range creds {
 		var credential string
 		if password, ok := cred["password"]; ok && password != "" {
@@ -238,35 +607,135 @@ func checkCredAccess(ctx context.Context, job *model.Job, creds []model.Credenti
 		if token, ok := cred["token"]; ok && token != "" {
 			credential, _ = token.(string)
 		}
-		if !strings.HasPrefix(credential, "ghp_") {
-			continue
+		switch {
+		case hasAnyPrefix(credential, scopedTokenPrefixes):
+			if err := checkTokenScopes(ctx, apiEndpoint, credential); err != nil {
+				return err
+			}
+		case hasAnyPrefix(credential, permissionTokenPrefixes):
+			// the permissions are per repository, so there's nothing to check without one
+			if job == nil || job.Source.Repo == "" {
+				continue
+			}
+			if err := checkTokenPermissions(ctx, apiEndpoint, job.Source.Repo, credential, allowedPermissions); err != nil {
+				return err
+			}
 		}
-		apiEndpoint := defaultApiEndpoint
-		if job != nil && job.Source.APIEndpoint != nil && *job.Source.APIEndpoint != "" {
-			apiEndpoint = *job.Source.APIEndpoint
+	}
+	return nil
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
 		}
-		r, err := http.NewRequestWithContext(ctx, "GET", apiEndpoint, http.NoBody)
-		if err != nil {
-			return fmt.Errorf("failed creating request: %w", err)
+	}
+	return false
+}
+
+func githubAPIRequest(ctx context.Context, method, url, token string, body io.Reader) (*http.Response, error) {
+	r, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating request: %w", err)
+	}
+	r.Header.Set("Authorization", fmt.Sprintf("token %s", token))
+	r.Header.Set("User-Agent", "dependabot-cli")
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed making request: %w", err)
+	}
+	return resp, nil
+}
+
+// writeScopes are the OAuth scopes that can write without saying so in their name, like repo, which can push.
+var writeScopes = []string{"repo", "public_repo", "workflow"}
+
+// checkTokenScopes rejects a token with an OAuth scope that can write or delete.
+func checkTokenScopes(ctx context.Context, apiEndpoint, token string) error {
+	resp, err := githubAPIRequest(ctx, http.MethodGet, apiEndpoint, token, http.NoBody)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed request to GitHub API to check access: %s", resp.Status)
+	}
+	for _, scope := range strings.Split(resp.Header.Get("X-OAuth-Scopes"), ",") {
+		scope = strings.TrimSpace(scope)
+		if strings.Contains(scope, "write") || strings.Contains(scope, "delete") ||
+			strings.HasPrefix(scope, "admin:") || slices.Contains(writeScopes, scope) {
+			return fmt.Errorf("%w: the token has the %s scope", ErrWriteAccess, scope)
 		}
-		r.Header.Set("Authorization", fmt.Sprintf("token %s", credential))
-		r.Header.Set("User-Agent", "dependabot-cli")
-		resp, err := http.DefaultClient.Do(r)
-		if err != nil {
-			return fmt.Errorf("failed making request: %w", err)
+	}
+	return nil
+}
+
+// checkTokenPermissions rejects a token with a permission on the repository that isn't allowed,
+// like push, which a token with contents: write has.
+func checkTokenPermissions(ctx context.Context, apiEndpoint, repo, token string, allowed []string) error {
+	apiEndpoint = strings.TrimSuffix(apiEndpoint, "/")
+	resp, err := githubAPIRequest(ctx, http.MethodGet, apiEndpoint+"/repos/"+repo, token, http.NoBody)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// a token that can't see the repository can't write to it, but it may be the wrong token
+	if resp.StatusCode == http.StatusNotFound {
+		log.Printf("the token can't see %s, so its permissions on it weren't checked", repo)
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed request to GitHub API to check access: %s", resp.Status)
+	}
+	var repository struct {
+		Permissions map[string]bool `json:"permissions"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&repository); err != nil {
+		return fmt.Errorf("failed to parse repository from GitHub API: %w", err)
+	}
+	if repository.Permissions == nil {
+		if !strings.HasPrefix(token, "ghs_") {
+			return fmt.Errorf("%w: can't verify the token's permissions on %s, the GitHub API didn't return them", ErrWriteAccess, repo)
 		}
-		defer resp.Body.Close()
-		if resp.StatusCode != http.StatusOK {
-			return fmt.Errorf("failed request to GitHub API to check access: %s", resp.Status)
+		// the repository's permissions are a user's, so an installation's are checked with what it's granted
+		if repository.Permissions, err = installationPermissions(ctx, apiEndpoint, repo, token); err != nil {
+			return err
 		}
-		scopes := resp.Header.Get("X-OAuth-Scopes")
-		if strings.Contains(scopes, "write") || strings.Contains(scopes, "delete") {
-			return ErrWriteAccess
+	}
+	permissions := make([]string, 0, len(repository.Permissions))
+	for permission, granted := range repository.Permissions {
+		if granted && !slices.Contains(allowed, permission) {
+			permissions = append(permissions, permission)
 		}
 	}
+	if len(permissions) > 0 {
+		sort.Strings(permissions)
+		return fmt.Errorf("%w: the token has %s permission on %s", ErrWriteAccess, strings.Join(permissions, ", "), repo)
+	}
 	return nil
 }
 
+// installationPermissions returns the repository permissions a GitHub App installation token has been granted,
+// as the permissions the GitHub API reports for a user. A token without contents: write is refused before the
+// request's body is validated, so a request to create an invalid blob shows whether it can push, without
+// creating anything.
+func installationPermissions(ctx context.Context, apiEndpoint, repo, token string) (map[string]bool, error) {
+	resp, err := githubAPIRequest(ctx, http.MethodPost, apiEndpoint+"/repos/"+repo+"/git/blobs", token, strings.NewReader("{}"))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusUnprocessableEntity:
+		return map[string]bool{"pull": true, "push": true}, nil
+	case http.StatusForbidden, http.StatusNotFound:
+		return map[string]bool{"pull": true}, nil
+	default:
+		return nil, fmt.Errorf("%w: can't verify the installation's permissions on %s: %s", ErrWriteAccess, repo, resp.Status)
+	}
+}
+
 var packageManagerLookup = map[string]string{
 	"bazel":          "bazel",
 	"bun":            "bun",
@@ -377,7 +846,7 @@ func generateIgnoreConditions(params *RunParams, actual *model.SmokeTest) error
 	return nil
 }
 
-func runContainers(ctx context.Context, params RunParams) (err error) {
+func runContainers(ctx context.Context, params RunParams, artifacts *Artifacts, metrics *Metrics, tracing *tracing) (err error) {
 	var cli *client.Client
 	cli, err = client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
@@ -385,46 +854,83 @@ func runContainers(ctx context.Context, params RunParams) (err error) {
 	}
 
 	if params.PullImages {
-		err = pullImage(ctx, cli, params.ProxyImage)
+		err = metrics.Time("pull proxy image", func() error { return pullImage(ctx, cli, params.ProxyImage) })
 		if err != nil {
 			return err
 		}
 
 		if params.CollectorConfigPath != "" {
-			err = pullImage(ctx, cli, params.CollectorImage)
+			err = metrics.Time("pull collector image", func() error { return pullImage(ctx, cli, params.CollectorImage) })
 			if err != nil {
 				fmt.Println("Failed to pull OpenTelemetry collector image:", err)
 			}
 		}
 
-		err = pullImage(ctx, cli, params.UpdaterImage)
+		err = metrics.Time("pull updater image", func() error { return pullImage(ctx, cli, params.UpdaterImage) })
 		if err != nil {
 			return err
 		}
 
 		if params.Job.UseCaseInsensitiveFileSystem() {
-			err = pullImage(ctx, cli, params.StorageImage)
+			err = metrics.Time("pull storage image", func() error { return pullImage(ctx, cli, params.StorageImage) })
 			if err != nil {
 				return err
 			}
 		}
 	}
 
+	artifacts.RecordImage(ctx, cli, "proxy", params.ProxyImage)
+	artifacts.RecordImage(ctx, cli, "updater", params.UpdaterImage)
+	if params.CollectorConfigPath != "" {
+		artifacts.RecordImage(ctx, cli, "collector", params.CollectorImage)
+	}
+	if params.Job.UseCaseInsensitiveFileSystem() {
+		artifacts.RecordImage(ctx, cli, "storage", params.StorageImage)
+	}
+
+	done := metrics.Start("create networks")
 	networks, err := NewNetworks(ctx, cli)
+	done()
 	if err != nil {
 		return fmt.Errorf("failed to create networks: %w", err)
 	}
-	defer networks.Close()
+	// kept is set when the containers are left running after a failure with --keep-on-failure
+	var kept bool
+	defer func() {
+		if !kept {
+			_ = networks.Close()
+		}
+	}()
+
+	if params.recorder != nil {
+		// a replay is offline, so the recorder is only reachable over the no-internet network
+		var gateway string
+		if params.ReplayPath != "" {
+			gateway = networks.noInternetGateway
+		}
+		if err = params.recorder.Listen(recorderHost(gateway)); err != nil {
+			return err
+		}
+	}
 
+	done = metrics.Start("start proxy")
 	prox, err := NewProxy(ctx, cli, &params, networks)
+	done()
 	if err != nil {
 		return err
 	}
 	defer func() {
+		if kept {
+			return
+		}
 		if proxyErr := prox.Close(); proxyErr != nil {
 			err = proxyErr
 		}
 	}()
+	defer metrics.SampleStats(ctx, cli, "proxy", prox.containerID)()
+
+	artifacts.WriteJSON(artifactProxyConfig, redactConfig(&Config{Credentials: params.Creds, CA: prox.ca}))
+	artifacts.CaptureLogs(LogSourceProxy, artifactProxyLogs)
 
 	// proxy logs interfere with debugging output
 	if !params.Debug {
@@ -433,25 +939,56 @@ func runContainers(ctx context.Context, params RunParams) (err error) {
 
 	var collector *Collector
 	if params.CollectorConfigPath != "" {
+		done = metrics.Start("start collector")
 		collector, err = NewCollector(ctx, cli, networks, &params, prox)
+		done()
 		if err != nil {
 			fmt.Println("Failed to create OpenTelemetry collector:", err)
 		}
+		artifacts.CaptureLogs(LogSourceOTel, artifactCollectorLogs)
 		if !params.Debug {
 			go collector.TailLogs(ctx, cli)
 		}
-		defer collector.Close()
+		defer func() {
+			if !kept {
+				_ = collector.Close()
+			}
+		}()
 	}
 
+	done = metrics.Start("start updater")
 	updater, err := NewUpdater(ctx, cli, networks, &params, prox, collector)
+	done()
 	if err != nil {
 		return err
 	}
 	defer func() {
+		if kept {
+			return
+		}
 		if updaterErr := updater.Close(); updaterErr != nil {
 			err = updaterErr
 		}
 	}()
+	defer metrics.SampleStats(ctx, cli, "updater", updater.containerID)()
+	defer func() {
+		failed := err != nil || (updater.ExitCode != nil && *updater.ExitCode != 0)
+		if !params.KeepOnFailure || !failed {
+			return
+		}
+		run, keepErr := keepRun(&params, networks, prox, collector, updater)
+		if keepErr != nil {
+			log.Println("failed to keep the containers:", keepErr)
+			return
+		}
+		kept = true
+		log.Printf("kept the containers of run %s, open a shell with `dependabot attach %s` and remove them with `dependabot cleanup %s`", run.ID, run.ID, run.ID)
+	}()
+
+	artifacts.CaptureLogs(LogSourceUpdater, artifactUpdaterLogs)
+	if job, jobErr := (JobFile{Job: params.Job}).ToJSON(); jobErr == nil {
+		artifacts.WriteFile(artifactJob, []byte(job))
+	}
 
 	// put the clone dir in the updater container to be used by during the update
 	if params.LocalDir != "" {
@@ -460,13 +997,19 @@ func runContainers(ctx context.Context, params RunParams) (err error) {
 			// since the updater is using the storage container, we need to populate the repo on that device because that's the directory that will be used for the update
 			containerDir = caseSensitiveRepoContentsPath
 		}
-		if err = putCloneDir(ctx, cli, updater, params.LocalDir, containerDir); err != nil {
+		err = metrics.Time("copy local directory", func() error {
+			return putCloneDir(ctx, cli, updater, params.LocalDir, containerDir)
+		})
+		if err != nil {
 			return err
 		}
 	}
 
 	// update CA certificates as root prior to start debug shell or running dependabot commands
-	if err := updater.RunCmd(ctx, "update-ca-certificates", root); err != nil {
+	err = metrics.Time("update-ca-certificates", func() error {
+		return updater.RunCmd(ctx, "update-ca-certificates", root)
+	})
+	if err != nil {
 		return err
 	}
 
@@ -474,35 +1017,76 @@ func runContainers(ctx context.Context, params RunParams) (err error) {
 		if err := updater.RunShell(ctx, prox.url, params.ApiUrl, params.Job, params.UpdaterEnvironmentVariables); err != nil {
 			return err
 		}
+	} else if params.Exec != "" || params.ExecFile != "" {
+		env := userEnv(prox.url, params.ApiUrl, params.Job, params.UpdaterEnvironmentVariables)
+		if err = runExec(ctx, cli, updater, &params, metrics, env); err != nil {
+			return err
+		}
 	} else {
 		// Run dependabot commands as a dependabot user
 		env := userEnv(prox.url, params.ApiUrl, params.Job, params.UpdaterEnvironmentVariables)
 		if params.Flamegraph {
 			env = append(env, "FLAMEGRAPH=1")
 		}
-		if err := updater.RunCmd(ctx, runCmds[params.Job.Command], dependabot, env...); err != nil {
-			return err
+		for _, step := range runCmds[params.Job.Command] {
+			err = metrics.Time(step, func() error {
+				return updater.RunCmd(ctx, "bin/run "+step, dependabot, env...)
+			})
+			if err != nil {
+				return err
+			}
+			// later steps depend on the earlier ones, like the shell's && did
+			if *updater.ExitCode != 0 {
+				break
+			}
 		}
 		if params.Flamegraph {
-			getFromContainer(ctx, cli, updater.containerID, "/tmp/dependabot-flamegraph.html")
+			getFromContainer(ctx, cli, updater.containerID, "/tmp/dependabot-flamegraph.html", artifacts.Path(artifactFlamegraph, "flamegraph.html"))
 		}
 		// If the exit code is non-zero, error when using the `update` subcommand, but not the `test` subcommand.
 		if params.Expected == nil && *updater.ExitCode != 0 {
-			return fmt.Errorf("updater exited with code %d", *updater.ExitCode)
+			return &UpdaterExitError{Code: *updater.ExitCode}
 		}
 	}
 
 	return nil
 }
 
-func getFromContainer(ctx context.Context, cli *client.Client, containerID, srcPath string) {
+// runExec runs the --exec command or --exec-file script as the dependabot user in place of the updater's commands.
+func runExec(ctx context.Context, cli *client.Client, updater *Updater, params *RunParams, metrics *Metrics, env []string) error {
+	cmd := params.Exec
+	if params.ExecFile != "" {
+		script, err := os.ReadFile(params.ExecFile)
+		if err != nil {
+			return fmt.Errorf("failed to read exec file: %w", err)
+		}
+		// copied rather than passed to sh -c so the script's shebang is used
+		t, err := tarball(guestExecScript, string(script))
+		if err != nil {
+			return fmt.Errorf("failed to create exec file tarball: %w", err)
+		}
+		if err = cli.CopyToContainer(ctx, updater.containerID, "/", t, container.CopyToContainerOptions{}); err != nil {
+			return fmt.Errorf("failed to copy exec file to container: %w", err)
+		}
+		cmd = guestExecScript
+	}
+	if err := metrics.Time("exec", func() error { return updater.RunCmd(ctx, cmd, dependabot, env...) }); err != nil {
+		return err
+	}
+	if *updater.ExitCode != 0 {
+		return &ExecExitError{Code: *updater.ExitCode}
+	}
+	return nil
+}
+
+func getFromContainer(ctx context.Context, cli *client.Client, containerID, srcPath, dstPath string) {
 	reader, _, err := cli.CopyFromContainer(ctx, containerID, srcPath)
 	if err != nil {
 		log.Println("Failed to get from container:", err)
 		return
 	}
 	defer reader.Close()
-	outFile, err := os.Create("flamegraph.html")
+	outFile, err := os.Create(dstPath)
 	if err != nil {
 		log.Println("Failed to create file while getting from container:", err)
 		return
@@ -517,6 +1101,9 @@ func getFromContainer(ctx context.Context, cli *client.Client, containerID, srcP
 }
 
 func putCloneDir(ctx context.Context, cli *client.Client, updater *Updater, localDir, containerDir string) error {
+	ctx, span := tracer.Start(ctx, "copy local directory", trace.WithAttributes(attribute.String("local-dir", localDir)))
+	defer span.End()
+
 	// Docker won't create the directory, so we have to do it first.
 	cmd := fmt.Sprintf("mkdir -p %s", containerDir)
 	err := updater.RunCmd(ctx, cmd, dependabot)
@@ -559,6 +1146,9 @@ func putCloneDir(ctx context.Context, cli *client.Client, updater *Updater, loca
 }
 
 func pullImage(ctx context.Context, cli *client.Client, imageName string) error {
+	ctx, span := tracer.Start(ctx, "pull image", trace.WithAttributes(attribute.String("image", imageName)))
+	defer span.End()
+
 	inspect, err := cli.ImageInspect(ctx, imageName)
 	if err != nil {
 		// Image doesn't exist locally, pull it
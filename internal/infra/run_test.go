@@ -2,12 +2,17 @@ package infra
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"reflect"
+	"runtime"
+	"strings"
 	"testing"
 	"time"
 
@@ -79,7 +84,7 @@ func Test_checkCredAccess(t *testing.T) {
 		credentials := []model.Credential{{
 			"token": "ghp_fake",
 		}}
-		err := checkCredAccess(context.Background(), nil, credentials)
+		err := checkCredAccess(context.Background(), nil, credentials, nil)
 		if !errors.Is(err, ErrWriteAccess) {
 			t.Error("unexpected error", err)
 		}
@@ -92,13 +97,122 @@ func Test_checkCredAccess(t *testing.T) {
 			"token": "ghp_fake",
 		}}
 		job := &model.Job{Source: model.Source{APIEndpoint: &addr}}
-		err := checkCredAccess(context.Background(), job, credentials)
+		err := checkCredAccess(context.Background(), job, credentials, nil)
 		if !errors.Is(err, ErrWriteAccess) {
 			t.Error("unexpected error", err)
 		}
 	})
 }
 
+// fakeGitHubAPI answers like the GitHub API for a token with each of the given repository permissions. A token
+// with nil permissions gets a repository without them, like an installation token, and can push if it's named so.
+func fakeGitHubAPI(t *testing.T, scopes string, permissions map[string][]string) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "token ")
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("X-OAuth-Scopes", scopes)
+			_, _ = w.Write([]byte("{}"))
+		case "/repos/dependabot/cli":
+			granted, ok := permissions[token]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			repo := map[string]any{"full_name": "dependabot/cli"}
+			if granted != nil {
+				repo["permissions"] = map[string]bool{}
+			}
+			for _, p := range granted {
+				repo["permissions"].(map[string]bool)[p] = true
+			}
+			_ = json.NewEncoder(w).Encode(repo)
+		case "/repos/dependabot/cli/git/blobs":
+			if r.Method != http.MethodPost || !strings.Contains(token, "write") {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			// the content is missing
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func Test_checkCredAccess_tokenTypes(t *testing.T) {
+	api := fakeGitHubAPI(t, "read:packages", map[string][]string{
+		"github_pat_read":  {"pull"},
+		"github_pat_write": {"pull", "push"},
+		"ghs_read":         {"pull", "triage"},
+		"ghs_write":        {"pull", "push", "maintain"},
+		"ghu_write":        {"pull", "push"},
+		"gho_write":        {"pull", "push"},
+		"ghs_app_read":     nil,
+		"ghs_app_write":    nil,
+		"github_pat_none":  nil,
+	})
+	job := &model.Job{Source: model.Source{Repo: "dependabot/cli", APIEndpoint: &api}}
+
+	tests := []struct {
+		token       string
+		allowed     []string
+		expectedErr string
+	}{
+		{token: "github_pat_read"},
+		{token: "github_pat_write", expectedErr: "the token has push permission on dependabot/cli"},
+		{token: "ghs_read"},
+		{token: "ghs_write", expectedErr: "the token has maintain, push permission on dependabot/cli"},
+		{token: "ghu_write", expectedErr: "the token has push permission on dependabot/cli"},
+		{token: "ghu_write", allowed: []string{"pull", "push"}},
+		{token: "ghs_read", allowed: []string{"pull"}, expectedErr: "the token has triage permission on dependabot/cli"},
+		// a token that can't see the repository can't write to it
+		{token: "github_pat_other"},
+		// an installation's permissions are checked with what it can do
+		{token: "ghs_app_read"},
+		{token: "ghs_app_write", expectedErr: "the token has push permission on dependabot/cli"},
+		{token: "ghs_app_write", allowed: []string{"pull", "push"}},
+		{token: "github_pat_none", expectedErr: "can't verify the token's permissions on dependabot/cli, the GitHub API didn't return them"},
+		// OAuth tokens have scopes, which are checked instead
+		{token: "gho_write"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s allowed %v", tt.token, tt.allowed), func(t *testing.T) {
+			err := checkCredAccess(context.Background(), job, []model.Credential{{"token": tt.token}}, tt.allowed)
+			if tt.expectedErr == "" {
+				if err != nil {
+					t.Errorf("expected no error, got %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrWriteAccess) || !strings.HasSuffix(err.Error(), tt.expectedErr) {
+				t.Errorf("expected ErrWriteAccess ending with %q, got %v", tt.expectedErr, err)
+			}
+		})
+	}
+
+	t.Run("OAuth tokens with write scopes", func(t *testing.T) {
+		api := fakeGitHubAPI(t, "repo, write:packages", nil)
+		job := &model.Job{Source: model.Source{Repo: "dependabot/cli", APIEndpoint: &api}}
+		err := checkCredAccess(context.Background(), job, []model.Credential{{"password": "gho_fake"}}, nil)
+		if !errors.Is(err, ErrWriteAccess) {
+			t.Errorf("expected ErrWriteAccess, got %v", err)
+		}
+	})
+	t.Run("OAuth tokens that can push", func(t *testing.T) {
+		for _, scope := range []string{"repo", "public_repo", "read:packages, admin:org"} {
+			api := fakeGitHubAPI(t, scope, nil)
+			job := &model.Job{Source: model.Source{Repo: "dependabot/cli", APIEndpoint: &api}}
+			err := checkCredAccess(context.Background(), job, []model.Credential{{"token": "ghp_fake"}}, nil)
+			if !errors.Is(err, ErrWriteAccess) {
+				t.Errorf("expected ErrWriteAccess for %s, got %v", scope, err)
+			}
+		}
+	})
+}
+
 func Test_expandEnvironmentVariables(t *testing.T) {
 	t.Run("injects environment variables", func(t *testing.T) {
 		os.Setenv("ENV1", "value1")
@@ -220,3 +334,59 @@ func Test_generateIgnoreConditions(t *testing.T) {
 		}
 	})
 }
+
+func TestRunParams_Validate(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "script.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\nbin/run fetch_files\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		params RunParams
+		valid  bool
+	}{
+		{"exec", RunParams{Exec: "bin/run fetch_files"}, true},
+		{"exec file", RunParams{ExecFile: script}, true},
+		{"exec and exec file", RunParams{Exec: "ls", ExecFile: script}, false},
+		{"exec in debug mode", RunParams{Exec: "ls", Debug: true}, false},
+		{"missing exec file", RunParams{ExecFile: script + ".missing"}, false},
+		{"replay", RunParams{ReplayPath: script}, runtime.GOOS == "linux"},
+		{"replay with preflight", RunParams{ReplayPath: script, Preflight: true}, false},
+		{"collector config and telemetry dir", RunParams{CollectorConfigPath: "config.yml", TelemetryDir: "telemetry"}, false},
+		{"keep on failure", RunParams{KeepOnFailure: true}, true},
+		{"keep on failure with HAR", RunParams{KeepOnFailure: true, HARPath: "run.har"}, false},
+		{"keep on failure with allowed hosts", RunParams{KeepOnFailure: true, AllowedHosts: []string{"registry.npmjs.org"}}, false},
+		{"keep on failure with restricted egress", RunParams{KeepOnFailure: true, RestrictEgress: true}, false},
+		{"collector config", RunParams{CollectorConfigPath: "config.yml", OTLPEndpoint: "http://localhost:4318"}, true},
+		{"collector config without an OTLP endpoint", RunParams{CollectorConfigPath: "config.yml"}, false},
+		{"allowed hosts", RunParams{AllowedHosts: []string{"registry.npmjs.org", "*.example.com"}}, true},
+		{"allowed host with a URL", RunParams{AllowedHosts: []string{"https://registry.npmjs.org"}}, false},
+		{"allowed host with a partial wildcard", RunParams{AllowedHosts: []string{"*example.com"}}, false},
+		{"CA cert without key", RunParams{CACertPath: "ca.crt"}, false},
+		{"CA and CA dir", RunParams{CACertPath: "ca.crt", CAKeyPath: "ca.key", CADir: "ca"}, false},
+		{"any order subset", RunParams{ExpectedOrder: model.OrderAny, ExpectedMode: model.ModeSubset}, true},
+		{"unknown order", RunParams{ExpectedOrder: "random"}, false},
+		{"unknown mode", RunParams{ExpectedMode: "superset"}, false},
+		{"update expectations", RunParams{UpdateExpectations: true, InputName: "smoke-test.yml"}, true},
+		{"update expectations without a file", RunParams{UpdateExpectations: true}, false},
+		{"ECDSA CA", RunParams{CAKeyType: KeyTypeECDSA}, true},
+		{"unknown CA key type", RunParams{CAKeyType: "dsa"}, false},
+		{"negative CA validity", RunParams{CAValidity: -time.Hour}, false},
+		{"missing proxy cert", RunParams{ProxyCertPaths: []string{script + ".missing"}}, false},
+		{"updater cert without certificates", RunParams{UpdaterCertPaths: []string{script}}, false},
+		{"API fault", RunParams{APIFaults: []model.APIFault{{Endpoint: "create_pull_request", Status: 500}}}, true},
+		{"API fault without a failure", RunParams{APIFaults: []model.APIFault{{Endpoint: "create_pull_request"}}}, false},
+		{"missing fault profile", RunParams{APIFaultsPath: script + ".missing"}, false},
+		{"forwarding URL", RunParams{ForwardTo: []string{"https://example.com/hook"}, ForwardBatch: 10}, true},
+		{"forwarding URL without a scheme", RunParams{ForwardTo: []string{"example.com/hook"}}, false},
+		{"negative forwarding batch", RunParams{ForwardTo: []string{"https://example.com/hook"}, ForwardBatch: -1}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.params.Job = &model.Job{}
+			if err := tt.params.Validate(); (err == nil) != tt.valid {
+				t.Errorf("expected valid to be %v, got error %v", tt.valid, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,358 @@
+package infra
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/dependabot/cli/internal/model"
+)
+
+// Summary is a human-readable report of the pull request changes and errors from a run.
+type Summary struct {
+	Updates   []SummaryUpdate
+	Errors    []SummaryError
+	Phases    []Phase
+	Resources []ResourceUsage
+	Blocked   []BlockedHost
+	// Metrics and Ecosystems are only reported with --record-metrics
+	Metrics    []MetricCount
+	Ecosystems []model.Ecosystem
+}
+
+// SummaryUpdate is a single dependency change proposed by the updater.
+type SummaryUpdate struct {
+	Action     string
+	Dependency string
+	From       string
+	To         string
+	Bump       string
+	Group      string
+	Directory  string
+}
+
+// SummaryError is an error the updater reported to the API.
+type SummaryError struct {
+	Kind      string
+	ErrorType string
+	Details   string
+}
+
+// unknownVersion is shown for a version or bump the outputs and the job don't have.
+const unknownVersion = "unknown"
+
+// NewSummary builds a Summary from the outputs recorded by the API. The job's existing pull requests give
+// the versions of the dependencies whose pull requests are updated or closed, which the outputs don't have.
+func NewSummary(outputs []model.Output, job *model.Job) *Summary {
+	s := &Summary{}
+	known := knownVersions(outputs, job)
+	for _, out := range outputs {
+		switch data := out.Expect.Data.(type) {
+		case model.CreatePullRequest:
+			group := groupName(data.DependencyGroup)
+			for _, dep := range data.Dependencies {
+				s.Updates = append(s.Updates, summarizeDependency("created", dep, group, data.UpdatedDependencyFiles))
+			}
+		case model.UpdatePullRequest:
+			group := groupName(data.DependencyGroup)
+			for _, name := range data.DependencyNames {
+				s.Updates = append(s.Updates, summarizeName("updated", name, group, filesDirectory(data.UpdatedDependencyFiles), known))
+			}
+		case model.ClosePullRequest:
+			for _, name := range data.DependencyNames {
+				s.Updates = append(s.Updates, summarizeName("closed ("+data.Reason+")", name, "", "", known))
+			}
+		case model.RecordUpdateJobError:
+			s.Errors = append(s.Errors, SummaryError{Kind: out.Type, ErrorType: data.ErrorType, Details: errorDetails(data.ErrorDetails)})
+		case model.RecordUpdateJobUnknownError:
+			s.Errors = append(s.Errors, SummaryError{Kind: out.Type, ErrorType: data.ErrorType, Details: errorDetails(data.ErrorDetails)})
+		}
+	}
+	return s
+}
+
+// Write renders the summary as tables.
+func (s *Summary) Write(w io.Writer) error {
+	if len(s.Updates) == 0 && len(s.Errors) == 0 {
+		if _, err := fmt.Fprintln(w, "No changes to Dependabot pull requests"); err != nil {
+			return err
+		}
+	}
+	if len(s.Updates) > 0 {
+		rows := make([][]string, 0, len(s.Updates))
+		for _, u := range s.Updates {
+			rows = append(rows, []string{u.Action, u.Dependency, u.From, u.To, u.Bump, u.Group, u.Directory})
+		}
+		header := []string{"action", "dependency", "from", "to", "bump", "group", "directory"}
+		if err := writeTable(w, "Changes to Dependabot Pull Requests", header, rows); err != nil {
+			return err
+		}
+	}
+	if len(s.Errors) > 0 {
+		rows := make([][]string, 0, len(s.Errors))
+		for _, e := range s.Errors {
+			rows = append(rows, []string{e.Kind, e.ErrorType, e.Details})
+		}
+		if err := writeTable(w, "Errors", []string{"kind", "error-type", "details"}, rows); err != nil {
+			return err
+		}
+	}
+	if len(s.Phases) > 0 {
+		rows := make([][]string, 0, len(s.Phases))
+		for _, p := range s.Phases {
+			rows = append(rows, []string{p.Name, fmt.Sprintf("%.1fs", p.Duration)})
+		}
+		if err := writeTable(w, "Timings", []string{"phase", "duration"}, rows); err != nil {
+			return err
+		}
+	}
+	if len(s.Resources) > 0 {
+		rows := make([][]string, 0, len(s.Resources))
+		for _, r := range s.Resources {
+			rows = append(rows, []string{r.Container, fmt.Sprintf("%.1f%%", r.PeakCPUPercent), formatBytes(r.PeakMemoryBytes)})
+		}
+		if err := writeTable(w, "Resources", []string{"container", "peak-cpu", "peak-memory"}, rows); err != nil {
+			return err
+		}
+	}
+	if len(s.Blocked) > 0 {
+		rows := make([][]string, 0, len(s.Blocked))
+		for _, b := range s.Blocked {
+			rows = append(rows, []string{b.Host, strconv.Itoa(b.Requests)})
+		}
+		if err := writeTable(w, "Blocked Requests", []string{"host", "requests"}, rows); err != nil {
+			return err
+		}
+	}
+	if len(s.Ecosystems) > 0 {
+		rows := make([][]string, 0, len(s.Ecosystems))
+		for _, e := range s.Ecosystems {
+			rows = append(rows, []string{e.Name, versionManager(e.PackageManager), versionManager(e.Language)})
+		}
+		if err := writeTable(w, "Ecosystems", []string{"ecosystem", "package-manager", "language"}, rows); err != nil {
+			return err
+		}
+	}
+	if len(s.Metrics) > 0 {
+		rows := make([][]string, 0, len(s.Metrics))
+		for _, m := range s.Metrics {
+			rows = append(rows, []string{m.Name, m.tagString(), strconv.Itoa(m.Count)})
+		}
+		if err := writeTable(w, "Updater Metrics", []string{"metric", "tags", "count"}, rows); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func versionManager(v model.VersionManager) string {
+	return strings.TrimSpace(v.Name + " " + v.Version)
+}
+
+func summarizeDependency(action string, dep model.Dependency, group string, files []model.DependencyFile) SummaryUpdate {
+	update := SummaryUpdate{
+		Action:     action,
+		Dependency: dep.Name,
+		From:       dep.PreviousVersion,
+		Group:      group,
+		Directory:  filesDirectory(files),
+	}
+	if dep.Directory != nil {
+		update.Directory = *dep.Directory
+	}
+	switch {
+	case dep.Removed:
+		update.Bump = "removed"
+	case dep.Version != nil:
+		update.To = *dep.Version
+		update.Bump = bumpType(dep.PreviousVersion, *dep.Version)
+	}
+	return update
+}
+
+// knownVersions returns the versions of each dependency in the run's new pull requests, or failing that, the
+// version the job's existing pull request for it updates to.
+func knownVersions(outputs []model.Output, job *model.Job) map[string]SummaryUpdate {
+	known := map[string]SummaryUpdate{}
+	if job != nil {
+		existing := slices.Clone(job.ExistingPullRequests)
+		for _, group := range job.ExistingGroupPullRequests {
+			existing = append(existing, group.Dependencies...)
+		}
+		for _, pr := range existing {
+			if pr.DependencyName != "" && pr.DependencyVersion != "" {
+				known[pr.DependencyName] = SummaryUpdate{To: pr.DependencyVersion}
+			}
+			if pr.Dependencies != nil {
+				for _, dep := range *pr.Dependencies {
+					if dep.DependencyVersion != "" {
+						known[dep.DependencyName] = SummaryUpdate{To: dep.DependencyVersion}
+					}
+				}
+			}
+		}
+	}
+	for _, out := range outputs {
+		if data, ok := out.Expect.Data.(model.CreatePullRequest); ok {
+			for _, dep := range data.Dependencies {
+				known[dep.Name] = summarizeDependency("", dep, "", nil)
+			}
+		}
+	}
+	return known
+}
+
+// summarizeName summarizes a dependency the output only has the name of, with the versions known for it.
+func summarizeName(action, name, group, directory string, known map[string]SummaryUpdate) SummaryUpdate {
+	update := SummaryUpdate{
+		Action:     action,
+		Dependency: name,
+		From:       unknownVersion,
+		To:         unknownVersion,
+		Bump:       unknownVersion,
+		Group:      group,
+		Directory:  directory,
+	}
+	versions := known[name]
+	if versions.From != "" {
+		update.From = versions.From
+	}
+	if versions.To != "" {
+		update.To = versions.To
+	}
+	if versions.Bump != "" {
+		update.Bump = versions.Bump
+	}
+	return update
+}
+
+func groupName(group map[string]any) string {
+	if name, ok := group["name"].(string); ok {
+		return name
+	}
+	return ""
+}
+
+func filesDirectory(files []model.DependencyFile) string {
+	for _, f := range files {
+		if f.Directory != "" {
+			return path.Clean(f.Directory)
+		}
+	}
+	return ""
+}
+
+func errorDetails(details map[string]any) string {
+	if len(details) == 0 {
+		return ""
+	}
+	// encoding/json sorts map keys, so this is stable between runs
+	data, err := json.Marshal(details)
+	if err != nil {
+		return fmt.Sprint(details)
+	}
+	return string(data)
+}
+
+// bumpType classifies the change between two versions as major, minor, or patch.
+// Versions that aren't dotted numbers, like git SHAs, can't be classified.
+func bumpType(from, to string) string {
+	if from == "" || to == "" {
+		return ""
+	}
+	a, okA := versionParts(from)
+	b, okB := versionParts(to)
+	if !okA || !okB {
+		return ""
+	}
+	names := []string{"major", "minor", "patch"}
+	for i := range names {
+		if a[i] != b[i] {
+			return names[i]
+		}
+	}
+	if from != to {
+		// only the pre-release or build metadata changed
+		return "patch"
+	}
+	return ""
+}
+
+var versionPattern = regexp.MustCompile(`^v?(\d+)(?:\.(\d+))?(?:\.(\d+))?(?:[-+.].*)?$`)
+
+// versionParts parses the leading major.minor.patch numbers of a version, missing parts are zero.
+func versionParts(v string) ([3]int, bool) {
+	var parts [3]int
+	matches := versionPattern.FindStringSubmatch(v)
+	if matches == nil {
+		return parts, false
+	}
+	for i, m := range matches[1:] {
+		if m != "" {
+			parts[i], _ = strconv.Atoi(m)
+		}
+	}
+	return parts, true
+}
+
+// writeTable renders rows in a box with a title, empty cells are shown as "-".
+func writeTable(w io.Writer, title string, header []string, rows [][]string) error {
+	widths := make([]int, len(header))
+	for i, h := range header {
+		widths[i] = utf8.RuneCountInString(h)
+	}
+	for _, row := range rows {
+		for i := range row {
+			if row[i] == "" {
+				row[i] = "-"
+			}
+			widths[i] = max(widths[i], utf8.RuneCountInString(row[i]))
+		}
+	}
+
+	inner := len(widths) - 1
+	for _, width := range widths {
+		inner += width + 2
+	}
+	if n := utf8.RuneCountInString(title) + 2; n > inner {
+		widths[len(widths)-1] += n - inner
+		inner = n
+	}
+
+	var b strings.Builder
+	separator := func() {
+		b.WriteString("+")
+		for _, width := range widths {
+			b.WriteString(strings.Repeat("-", width+2))
+			b.WriteString("+")
+		}
+		b.WriteString("\n")
+	}
+	line := func(cells []string) {
+		b.WriteString("|")
+		for i, cell := range cells {
+			b.WriteString(" " + cell + strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell)) + " |")
+		}
+		b.WriteString("\n")
+	}
+
+	b.WriteString("+" + strings.Repeat("-", inner) + "+\n")
+	pad := inner - utf8.RuneCountInString(title)
+	b.WriteString("|" + strings.Repeat(" ", pad/2) + title + strings.Repeat(" ", pad-pad/2) + "|\n")
+	separator()
+	line(header)
+	separator()
+	for _, row := range rows {
+		line(row)
+	}
+	separator()
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
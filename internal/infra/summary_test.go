@@ -0,0 +1,170 @@
+package infra
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/dependabot/cli/internal/model"
+)
+
+func Test_bumpType(t *testing.T) {
+	tests := []struct {
+		from, to string
+		expected string
+	}{
+		{"1.2.3", "2.0.0", "major"},
+		{"1.2.3", "1.3.0", "minor"},
+		{"1.2.3", "1.2.4", "patch"},
+		{"v1.2.3", "v1.2.4", "patch"},
+		{"1.2", "1.3", "minor"},
+		{"1.0.0-beta.1", "1.0.0", "patch"},
+		{"1.2.3", "1.2.3", ""},
+		{"", "1.2.3", ""},
+		{"1278c8d7503f9881eb969959446e2c3a5a0cce2d", "0a0a8a7f7b2b3c4d5e6f7a8b9c0d1e2f3a4b5c6d", ""},
+	}
+	for _, tt := range tests {
+		if actual := bumpType(tt.from, tt.to); actual != tt.expected {
+			t.Errorf("bumpType(%q, %q) = %q, expected %q", tt.from, tt.to, actual, tt.expected)
+		}
+	}
+}
+
+func TestNewSummary(t *testing.T) {
+	version := "3.1.0"
+	directory := "/api"
+	outputs := []model.Output{
+		{Type: "create_pull_request", Expect: model.UpdateWrapper{Data: model.CreatePullRequest{
+			Dependencies: []model.Dependency{{
+				Name:            "rsc.io/quote/v3",
+				PreviousVersion: "3.0.0",
+				Version:         &version,
+				Directory:       &directory,
+			}},
+			DependencyGroup: map[string]any{"name": "go-deps"},
+		}}},
+		{Type: "close_pull_request", Expect: model.UpdateWrapper{Data: model.ClosePullRequest{
+			DependencyNames: []string{"rsc.io/sampler"},
+			Reason:          "up_to_date",
+		}}},
+		{Type: "mark_as_processed", Expect: model.UpdateWrapper{Data: model.MarkAsProcessed{}}},
+		{Type: "record_update_job_error", Expect: model.UpdateWrapper{Data: model.RecordUpdateJobError{
+			ErrorType:    "dependency_file_not_found",
+			ErrorDetails: map[string]any{"file-path": "/go.mod"},
+		}}},
+	}
+
+	summary := NewSummary(outputs, nil)
+
+	if len(summary.Updates) != 2 {
+		t.Fatalf("expected 2 updates, got %d", len(summary.Updates))
+	}
+	expected := SummaryUpdate{
+		Action:     "created",
+		Dependency: "rsc.io/quote/v3",
+		From:       "3.0.0",
+		To:         "3.1.0",
+		Bump:       "minor",
+		Group:      "go-deps",
+		Directory:  "/api",
+	}
+	if summary.Updates[0] != expected {
+		t.Errorf("expected %+v, got %+v", expected, summary.Updates[0])
+	}
+	if summary.Updates[1].Action != "closed (up_to_date)" {
+		t.Errorf("unexpected close action %q", summary.Updates[1].Action)
+	}
+	if len(summary.Errors) != 1 || summary.Errors[0].ErrorType != "dependency_file_not_found" {
+		t.Fatalf("expected the job error to be summarized, got %+v", summary.Errors)
+	}
+
+	var buf bytes.Buffer
+	if err := summary.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"| created ", "rsc.io/quote/v3", "| minor ", `{"file-path":"/go.mod"}`} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("expected summary to contain %q:\n%s", s, buf.String())
+		}
+	}
+}
+
+func TestNewSummary_versions(t *testing.T) {
+	version := "1.5.0"
+	job := &model.Job{ExistingPullRequests: model.ExistingPullRequests{
+		{DependencyName: "rsc.io/sampler", DependencyVersion: "1.3.1"},
+	}}
+	outputs := []model.Output{
+		{Type: "update_pull_request", Expect: model.UpdateWrapper{Data: model.UpdatePullRequest{
+			DependencyNames: []string{"rsc.io/quote", "rsc.io/sampler", "golang.org/x/text"},
+		}}},
+		{Type: "create_pull_request", Expect: model.UpdateWrapper{Data: model.CreatePullRequest{
+			Dependencies: []model.Dependency{{Name: "rsc.io/quote", PreviousVersion: "1.4.0", Version: &version}},
+		}}},
+	}
+
+	summary := NewSummary(outputs, job)
+
+	expected := []SummaryUpdate{
+		{Action: "updated", Dependency: "rsc.io/quote", From: "1.4.0", To: "1.5.0", Bump: "minor"},
+		{Action: "updated", Dependency: "rsc.io/sampler", From: "unknown", To: "1.3.1", Bump: "unknown"},
+		{Action: "updated", Dependency: "golang.org/x/text", From: "unknown", To: "unknown", Bump: "unknown"},
+	}
+	if len(summary.Updates) != 4 {
+		t.Fatalf("expected 4 updates, got %+v", summary.Updates)
+	}
+	for i, e := range expected {
+		if summary.Updates[i] != e {
+			t.Errorf("expected %+v, got %+v", e, summary.Updates[i])
+		}
+	}
+}
+
+func TestSummary_Write_metrics(t *testing.T) {
+	summary := &Summary{
+		Phases:    []Phase{{Name: "fetch_files", Duration: 12.34}},
+		Resources: []ResourceUsage{{Container: "updater", PeakCPUPercent: 150, PeakMemoryBytes: 512 * 1024 * 1024, Samples: 3}},
+	}
+	var buf bytes.Buffer
+	if err := summary.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"No changes", "Timings", "| fetch_files | 12.3s ", "| updater   | 150.0%   | 512.0 MiB   |"} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("expected summary to contain %q:\n%s", s, buf.String())
+		}
+	}
+}
+
+func TestSummary_Write_blocked(t *testing.T) {
+	summary := &Summary{Blocked: []BlockedHost{{Host: "evil.example.com", Requests: 2}}}
+	var buf bytes.Buffer
+	if err := summary.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"Blocked Requests", "| evil.example.com | 2 "} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("expected summary to contain %q:\n%s", s, buf.String())
+		}
+	}
+}
+
+func TestSummary_Write_updaterMetrics(t *testing.T) {
+	summary := &Summary{
+		Ecosystems: []model.Ecosystem{{
+			Name:           "bundler",
+			PackageManager: model.VersionManager{Name: "bundler", Version: "2.7.2"},
+			Language:       model.VersionManager{Name: "ruby", Version: "3.4.1"},
+		}},
+		Metrics: []MetricCount{{Name: "updater.started", Tags: map[string]string{"package_manager": "bundler"}, Count: 2}},
+	}
+	var buf bytes.Buffer
+	if err := summary.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"Ecosystems", "| bundler   | bundler 2.7.2   | ruby 3.4.1 |", "Updater Metrics", "| updater.started | package_manager=bundler | 2 "} {
+		if !strings.Contains(buf.String(), s) {
+			t.Errorf("expected summary to contain %q:\n%s", s, buf.String())
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package infra
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Files written to the --telemetry-out directory.
+const (
+	TelemetryConfigFile = "collector-config.yaml"
+	TelemetryTracesFile = "traces.jsonl"
+	// TelemetryCLITracesFile has the CLI's own spans, which it writes from the host
+	TelemetryCLITracesFile = "cli-traces.jsonl"
+	TelemetryMetricsFile   = "metrics.jsonl"
+	TelemetryLogsFile      = "logs.jsonl"
+)
+
+// telemetryMountPath is where the --telemetry-out directory is mounted in the collector.
+const telemetryMountPath = "/telemetry"
+
+// telemetryConfig writes everything the collector receives to files as OTLP JSON, one batch per line.
+var telemetryConfig = fmt.Sprintf(`receivers:
+  otlp:
+    protocols:
+      grpc:
+        endpoint: 0.0.0.0:4317
+      http:
+        endpoint: 0.0.0.0:4318
+
+processors:
+  batch: {}
+
+exporters:
+  file/traces:
+    path: %[1]s/%[2]s
+  file/metrics:
+    path: %[1]s/%[3]s
+  file/logs:
+    path: %[1]s/%[4]s
+
+service:
+  pipelines:
+    traces:
+      receivers: [otlp]
+      processors: [batch]
+      exporters: [file/traces]
+    metrics:
+      receivers: [otlp]
+      processors: [batch]
+      exporters: [file/metrics]
+    logs:
+      receivers: [otlp]
+      processors: [batch]
+      exporters: [file/logs]
+`, telemetryMountPath, TelemetryTracesFile, TelemetryMetricsFile, TelemetryLogsFile)
+
+// prepareTelemetryDir creates the --telemetry-out directory and points the collector at a generated config in it.
+func prepareTelemetryDir(params *RunParams) error {
+	if params.TelemetryDir == "" {
+		return nil
+	}
+	dir, err := filepath.Abs(params.TelemetryDir)
+	if err != nil {
+		return fmt.Errorf("failed to resolve telemetry directory: %w", err)
+	}
+	if err = os.MkdirAll(dir, 0750); err != nil {
+		return fmt.Errorf("failed to create telemetry directory: %w", err)
+	}
+	configPath := filepath.Join(dir, TelemetryConfigFile)
+	if err = os.WriteFile(configPath, []byte(telemetryConfig), 0600); err != nil {
+		return fmt.Errorf("failed to write collector config: %w", err)
+	}
+	params.TelemetryDir = dir
+	params.CollectorConfigPath = configPath
+	return nil
+}
+
+// TelemetrySpan is a span read from the traces written by --telemetry-out.
+type TelemetrySpan struct {
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+	Name         string
+	Service      string
+	Start        time.Time
+	End          time.Time
+	Error        bool
+}
+
+// Duration is how long the span took.
+func (s *TelemetrySpan) Duration() time.Duration {
+	return s.End.Sub(s.Start)
+}
+
+// otlpTraces is the subset of the OTLP JSON encoding needed to show a span tree.
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpScopeSpans struct {
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	} `json:"status"`
+}
+
+type otlpAttribute struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue string `json:"stringValue"`
+	} `json:"value"`
+}
+
+func newOTLPAttribute(key, value string) otlpAttribute {
+	attr := otlpAttribute{Key: key}
+	attr.Value.StringValue = value
+	return attr
+}
+
+// otlpStatusError is the OTLP status code of a failed span.
+const otlpStatusError = 2
+
+// ReadSpans reads spans from OTLP JSON lines, as written by the collector's file exporter.
+func ReadSpans(r io.Reader) ([]TelemetrySpan, error) {
+	var spans []TelemetrySpan
+	scanner := bufio.NewScanner(r)
+	// a line is a whole batch of spans
+	scanner.Buffer(nil, 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var traces otlpTraces
+		if err := json.Unmarshal(scanner.Bytes(), &traces); err != nil {
+			return nil, fmt.Errorf("failed to parse line %d: %w", line, err)
+		}
+		for _, rs := range traces.ResourceSpans {
+			var service string
+			for _, attr := range rs.Resource.Attributes {
+				if attr.Key == "service.name" {
+					service = attr.Value.StringValue
+				}
+			}
+			for _, ss := range rs.ScopeSpans {
+				for _, s := range ss.Spans {
+					spans = append(spans, TelemetrySpan{
+						TraceID:      s.TraceID,
+						SpanID:       s.SpanID,
+						ParentSpanID: s.ParentSpanID,
+						Name:         s.Name,
+						Service:      service,
+						Start:        unixNano(s.StartTimeUnixNano),
+						End:          unixNano(s.EndTimeUnixNano),
+						Error:        s.Status.Code == otlpStatusError,
+					})
+				}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read spans: %w", err)
+	}
+	return spans, nil
+}
+
+func unixNano(s string) time.Time {
+	n, _ := strconv.ParseInt(s, 10, 64)
+	return time.Unix(0, n).UTC()
+}
+
+// WriteSpanTree prints each trace as a tree of spans in the order they started.
+// Spans whose parent wasn't recorded are shown as roots.
+func WriteSpanTree(w io.Writer, spans []TelemetrySpan) error {
+	ids := map[string]bool{}
+	for _, s := range spans {
+		ids[s.SpanID] = true
+	}
+	children := map[string][]TelemetrySpan{}
+	var roots []TelemetrySpan
+	for _, s := range spans {
+		if s.ParentSpanID == "" || !ids[s.ParentSpanID] {
+			roots = append(roots, s)
+		} else {
+			children[s.ParentSpanID] = append(children[s.ParentSpanID], s)
+		}
+	}
+	byStart := func(spans []TelemetrySpan) {
+		sort.SliceStable(spans, func(i, j int) bool { return spans[i].Start.Before(spans[j].Start) })
+	}
+	byStart(roots)
+	for _, c := range children {
+		byStart(c)
+	}
+
+	var write func(s TelemetrySpan, prefix, branch, indent string) error
+	write = func(s TelemetrySpan, prefix, branch, indent string) error {
+		line := fmt.Sprintf("%s%s%s (%s)", prefix, branch, s.Name, s.Duration().Round(time.Millisecond))
+		if s.Service != "" {
+			line += " [" + s.Service + "]"
+		}
+		if s.Error {
+			line += " ERROR"
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+		kids := children[s.SpanID]
+		for i, c := range kids {
+			if i == len(kids)-1 {
+				if err := write(c, prefix+indent, "└── ", "    "); err != nil {
+					return err
+				}
+			} else if err := write(c, prefix+indent, "├── ", "│   "); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, r := range roots {
+		if err := write(r, "", "", ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fileSpanExporter writes spans to a file as OTLP JSON lines, like the collector's file exporter,
+// so the CLI's spans are in the telemetry directory without it having to reach the collector.
+type fileSpanExporter struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func newFileSpanExporter(path string) (*fileSpanExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600) //nolint:gosec // the path is in the telemetry directory
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	return &fileSpanExporter{file: f}, nil
+}
+
+// ExportSpans implements sdktrace.SpanExporter.
+func (e *fileSpanExporter) ExportSpans(_ context.Context, spans []sdktrace.ReadOnlySpan) error {
+	if len(spans) == 0 {
+		return nil
+	}
+	var rs otlpResourceSpans
+	for _, attr := range spans[0].Resource().Attributes() {
+		rs.Resource.Attributes = append(rs.Resource.Attributes, newOTLPAttribute(string(attr.Key), attr.Value.Emit()))
+	}
+	var ss otlpScopeSpans
+	for _, span := range spans {
+		s := otlpSpan{
+			TraceID:           span.SpanContext().TraceID().String(),
+			SpanID:            span.SpanContext().SpanID().String(),
+			Name:              span.Name(),
+			StartTimeUnixNano: strconv.FormatInt(span.StartTime().UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.EndTime().UnixNano(), 10),
+		}
+		if span.Parent().HasSpanID() {
+			s.ParentSpanID = span.Parent().SpanID().String()
+		}
+		for _, attr := range span.Attributes() {
+			s.Attributes = append(s.Attributes, newOTLPAttribute(string(attr.Key), attr.Value.Emit()))
+		}
+		if span.Status().Code == codes.Error {
+			s.Status.Code = otlpStatusError
+			s.Status.Message = span.Status().Description
+		}
+		ss.Spans = append(ss.Spans, s)
+	}
+	rs.ScopeSpans = []otlpScopeSpans{ss}
+
+	line, err := json.Marshal(otlpTraces{ResourceSpans: []otlpResourceSpans{rs}})
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err = e.file.Write(append(line, '\n'))
+	return err
+}
+
+// Shutdown implements sdktrace.SpanExporter.
+func (e *fileSpanExporter) Shutdown(context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.file.Close()
+}
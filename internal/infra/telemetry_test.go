@@ -0,0 +1,54 @@
+package infra
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func Test_prepareTelemetryDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "telemetry")
+	params := RunParams{TelemetryDir: dir}
+	if err := prepareTelemetryDir(&params); err != nil {
+		t.Fatal(err)
+	}
+	if params.CollectorConfigPath != filepath.Join(dir, TelemetryConfigFile) {
+		t.Errorf("expected the collector to use the generated config, got %q", params.CollectorConfigPath)
+	}
+	config, err := os.ReadFile(params.CollectorConfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(config), "path: /telemetry/traces.jsonl") {
+		t.Errorf("expected traces to be written to the mounted directory:\n%s", config)
+	}
+}
+
+func TestWriteSpanTree(t *testing.T) {
+	// two batches, as the collector writes them, with the updater's span in the second
+	traces := `{"resourceSpans":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"dependabot-cli"}}]},"scopeSpans":[{"spans":[` +
+		`{"traceId":"t1","spanId":"a","name":"dependabot run","startTimeUnixNano":"1000000000","endTimeUnixNano":"11000000000"},` +
+		`{"traceId":"t1","spanId":"c","parentSpanId":"a","name":"bin/run update_files","startTimeUnixNano":"6000000000","endTimeUnixNano":"10000000000","status":{"code":2}},` +
+		`{"traceId":"t1","spanId":"b","parentSpanId":"a","name":"bin/run fetch_files","startTimeUnixNano":"2000000000","endTimeUnixNano":"5000000000"}]}]}]}
+{"resourceSpans":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"dependabot-updater"}}]},"scopeSpans":[{"spans":[` +
+		`{"traceId":"t1","spanId":"d","parentSpanId":"b","name":"fetch","startTimeUnixNano":"2500000000","endTimeUnixNano":"3000000000"}]}]}]}
+`
+	spans, err := ReadSpans(strings.NewReader(traces))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err = WriteSpanTree(&buf, spans); err != nil {
+		t.Fatal(err)
+	}
+	expected := `dependabot run (10s) [dependabot-cli]
+├── bin/run fetch_files (3s) [dependabot-cli]
+│   └── fetch (500ms) [dependabot-updater]
+└── bin/run update_files (4s) [dependabot-cli] ERROR
+`
+	if buf.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
@@ -0,0 +1,109 @@
+package infra
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// TracerName is the instrumentation scope of the spans created by the CLI.
+const TracerName = "github.com/dependabot/cli"
+
+// tracer uses the global provider, so it's a no-op unless tracing was started.
+var tracer = otel.Tracer(TracerName)
+
+// tracing exports the CLI's spans for a single run.
+// A nil *tracing is valid and does nothing, which is the case when there's nowhere to send spans.
+type tracing struct {
+	provider *sdktrace.TracerProvider
+	root     trace.Span
+	once     sync.Once
+}
+
+// startTracing starts the root span of the run. Spans are sent to endpoint if it's set,
+// otherwise they're written to the telemetry directory if there is one.
+func startTracing(ctx context.Context, endpoint, telemetryDir string) (context.Context, *tracing, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch {
+	case endpoint != "":
+		if exporter, err = otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint)); err != nil {
+			return ctx, nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+		}
+	case telemetryDir != "":
+		if exporter, err = newFileSpanExporter(filepath.Join(telemetryDir, TelemetryCLITracesFile)); err != nil {
+			return ctx, nil, err
+		}
+	default:
+		return ctx, nil, nil
+	}
+
+	t := &tracing{}
+	t.provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", "dependabot-cli"))),
+	)
+	otel.SetTracerProvider(t.provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	ctx, t.root = tracer.Start(ctx, "dependabot run")
+	return ctx, t, nil
+}
+
+// finish ends the root span and flushes every span.
+func (t *tracing) finish(err error) {
+	if t == nil {
+		return
+	}
+	t.once.Do(func() {
+		if err != nil {
+			t.root.RecordError(err)
+			t.root.SetStatus(codes.Error, err.Error())
+		}
+		t.root.End()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if flushErr := t.provider.ForceFlush(ctx); flushErr != nil {
+			log.Println("failed to export spans:", flushErr)
+		}
+	})
+}
+
+// shutdown finishes the run and stops the provider.
+func (t *tracing) shutdown(err error) {
+	if t == nil {
+		return
+	}
+	t.finish(err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = t.provider.Shutdown(ctx)
+	otel.SetTracerProvider(noop.NewTracerProvider())
+}
+
+// traceEnv returns the environment variables that make a process a child of the span in ctx.
+// The OpenTelemetry SDKs read TRACEPARENT and TRACESTATE the same as the HTTP headers.
+func traceEnv(ctx context.Context) []string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	env := make([]string, 0, len(carrier))
+	for _, key := range carrier.Keys() {
+		env = append(env, fmt.Sprintf("%s=%s", strings.ToUpper(key), carrier.Get(key)))
+	}
+	return env
+}
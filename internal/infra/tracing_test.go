@@ -0,0 +1,74 @@
+package infra
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func Test_traceEnv(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	provider := sdktrace.NewTracerProvider()
+	defer func() { _ = provider.Shutdown(context.Background()) }()
+
+	ctx, span := provider.Tracer(TracerName).Start(context.Background(), "bin/run fetch_files")
+	defer span.End()
+
+	env := traceEnv(ctx)
+	expected := "TRACEPARENT=00-" + span.SpanContext().TraceID().String() + "-" + span.SpanContext().SpanID().String()
+	if len(env) != 1 || !strings.HasPrefix(env[0], expected) {
+		t.Errorf("expected %q, got %v", expected, env)
+	}
+}
+
+func Test_startTracing(t *testing.T) {
+	t.Run("disabled without an endpoint or telemetry directory", func(t *testing.T) {
+		ctx, tracing, err := startTracing(context.Background(), "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tracing != nil || len(traceEnv(ctx)) != 0 {
+			t.Error("expected tracing to be disabled")
+		}
+		// a nil tracing is safe to use
+		tracing.finish(nil)
+		tracing.shutdown(nil)
+	})
+	t.Run("written to the telemetry directory", func(t *testing.T) {
+		dir := t.TempDir()
+		ctx, tracing, err := startTracing(context.Background(), "", dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, span := tracer.Start(ctx, "start proxy")
+		span.End()
+		tracing.shutdown(fmt.Errorf("proxy failed"))
+
+		f, err := os.Open(filepath.Join(dir, TelemetryCLITracesFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		spans, err := ReadSpans(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(spans) != 2 {
+			t.Fatalf("expected 2 spans, got %+v", spans)
+		}
+		run, proxy := spans[1], spans[0]
+		if run.Name != "dependabot run" || !run.Error || run.Service != "dependabot-cli" {
+			t.Errorf("unexpected root span %+v", run)
+		}
+		if proxy.Name != "start proxy" || proxy.ParentSpanID != run.SpanID || proxy.TraceID != run.TraceID {
+			t.Errorf("expected the span to be a child of the root span, got %+v", proxy)
+		}
+	})
+}
@@ -11,6 +11,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
@@ -22,8 +23,10 @@ import (
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/api/types/volume"
 	"github.com/docker/docker/client"
-	"github.com/goware/prefixer"
 	"github.com/moby/moby/pkg/stdcopy"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const jobID = "cli"
@@ -36,6 +39,8 @@ const (
 	guestInputDir = "/home/dependabot/dependabot-updater/job.json"
 	guestOutput   = "/home/dependabot/dependabot-updater/output.json"
 	guestRepoDir  = "/home/dependabot/dependabot-updater/repo"
+	// guestExecScript is where the --exec-file script is copied to
+	guestExecScript = "/tmp/dependabot-exec"
 
 	caseSensitiveContainerRoot    = "/dpdbot"
 	caseSensitiveRepoContentsPath = "/dpdbot/repo"
@@ -65,6 +70,9 @@ const (
 
 // NewUpdater starts the update container interactively running /bin/sh, so it does not stop.
 func NewUpdater(ctx context.Context, cli *client.Client, net *Networks, params *RunParams, prox *Proxy, collector *Collector) (*Updater, error) {
+	ctx, span := tracer.Start(ctx, "start updater")
+	defer span.End()
+
 	containerCfg := &container.Config{
 		User:  dependabot,
 		Image: params.UpdaterImage,
@@ -133,6 +141,16 @@ func NewUpdater(ctx context.Context, cli *client.Client, net *Networks, params *
 		return nil, err
 	}
 
+	// installed by update-ca-certificates before the update runs
+	certs, err := readCertificates(params.UpdaterCertPaths)
+	if err == nil {
+		err = putCertificates(ctx, cli, updaterContainer.ID, "custom-ca-cert", certs)
+	}
+	if err != nil {
+		updater.Close()
+		return nil, err
+	}
+
 	if err = cli.ContainerStart(ctx, updaterContainer.ID, container.StartOptions{}); err != nil {
 		updater.Close()
 		return nil, fmt.Errorf("failed to start updater container: %w", err)
@@ -392,11 +410,14 @@ func (u *Updater) RunShell(ctx context.Context, proxyURL string, apiUrl string,
 
 // RunCmd executes the update scripts as the dependabot user, blocks until complete.
 func (u *Updater) RunCmd(ctx context.Context, cmd, user string, env ...string) error {
+	ctx, span := tracer.Start(ctx, cmd, trace.WithAttributes(attribute.String("user", user)))
+	defer span.End()
+
 	execCreate, err := u.cli.ContainerExecCreate(ctx, u.containerID, container.ExecOptions{
 		AttachStdout: true,
 		AttachStderr: true,
 		User:         user,
-		Env:          env,
+		Env:          slices.Concat(env, traceEnv(ctx)),
 		Cmd:          []string{"/bin/sh", "-c", cmd},
 	})
 	if err != nil {
@@ -408,14 +429,14 @@ func (u *Updater) RunCmd(ctx context.Context, cmd, user string, env ...string) e
 		return fmt.Errorf("failed to start exec: %w", err)
 	}
 
-	r, w := io.Pipe()
-	go func() {
-		_, _ = io.Copy(os.Stderr, prefixer.New(r, "updater | "))
-	}()
+	stdout := LogWriter(LogSourceUpdater, LogStreamStdout)
+	stderr := LogWriter(LogSourceUpdater, LogStreamStderr)
 
 	ch := make(chan struct{})
 	go func() {
-		_, _ = stdcopy.StdCopy(w, w, execResp.Reader)
+		_, _ = stdcopy.StdCopy(stdout, stderr, execResp.Reader)
+		_ = stdout.Close()
+		_ = stderr.Close()
 		ch <- struct{}{}
 	}()
 
@@ -433,6 +454,10 @@ func (u *Updater) RunCmd(ctx context.Context, cmd, user string, env ...string) e
 	}
 
 	u.ExitCode = &execInspect.ExitCode
+	span.SetAttributes(attribute.Int("exit-code", execInspect.ExitCode))
+	if execInspect.ExitCode != 0 {
+		span.SetStatus(codes.Error, fmt.Sprintf("exited with code %d", execInspect.ExitCode))
+	}
 
 	return nil
 }
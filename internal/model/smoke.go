@@ -1,5 +1,13 @@
 package model
 
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
 type RunCommand string
 
 const (
@@ -10,12 +18,29 @@ const (
 	UpdateGraphCommand RunCommand = "graph"
 )
 
+// The orders and modes a smoke test's outputs can be matched in, the first of each is the default.
+const (
+	OrderStrict = "strict"
+	OrderAny    = "any"
+	ModeExact   = "exact"
+	ModeSubset  = "subset"
+)
+
 // SmokeTest is a way to test a job by asserting the outputs.
 type SmokeTest struct {
 	// Input is the input parameters
 	Input Input `yaml:"input"`
+	// Order is OrderAny to match each output against any unmet expectation, instead of the next one
+	Order string `yaml:"order,omitempty"`
+	// Mode is ModeSubset to only require the expected outputs, instead of exactly those outputs
+	Mode string `yaml:"mode,omitempty"`
 	// Output is the list of expected outputs
 	Output []Output `yaml:"output,omitempty"`
+	// InjectedFaults are the faults the fake API injected during the run
+	InjectedFaults []InjectedFault `yaml:"injected-faults,omitempty"`
+	// Metrics are the increment_metric and record_ecosystem_meta calls, kept with --record-metrics
+	// but never compared to expectations
+	Metrics []Output `yaml:"metrics,omitempty"`
 }
 
 // Input is the input to a job
@@ -24,6 +49,35 @@ type Input struct {
 	Job Job `yaml:"job"`
 	// Credentials is the registry info and tokens to pass to the Proxy
 	Credentials []Credential `yaml:"credentials,omitempty"`
+	// AllowedHosts restricts the hosts the Proxy can reach, in addition to the ones the job needs
+	AllowedHosts []string `json:"allowed-hosts,omitempty" yaml:"allowed-hosts,omitempty"`
+	// RestrictEgress restricts the Proxy to the hosts the job needs even when there are no AllowedHosts
+	RestrictEgress bool `json:"restrict-egress,omitempty" yaml:"restrict-egress,omitempty"`
+	// APIFaults are failures the fake API injects, to test how the updater handles them
+	APIFaults []APIFault `json:"api-faults,omitempty" yaml:"api-faults,omitempty"`
+}
+
+// APIFault is a failure the fake API injects instead of handling a call, the first one matching a call applies.
+type APIFault struct {
+	// Endpoint is the kind of call to fail, e.g. create_pull_request, or every kind if it's empty
+	Endpoint string `json:"endpoint,omitempty" yaml:"endpoint,omitempty"`
+	// Calls are the numbers of the calls to the endpoint to fail, starting at 1, or every call if it's empty
+	Calls []int `json:"calls,omitempty" yaml:"calls,omitempty"`
+	// Delay is how long to wait before responding, e.g. 5s
+	Delay string `json:"delay,omitempty" yaml:"delay,omitempty"`
+	// Status is a 4xx or 5xx status to respond with instead of handling the call
+	Status int `json:"status,omitempty" yaml:"status,omitempty"`
+	// Drop closes the connection without a response
+	Drop bool `json:"drop,omitempty" yaml:"drop,omitempty"`
+}
+
+// InjectedFault is a fault the fake API injected into a call.
+type InjectedFault struct {
+	Endpoint string `yaml:"endpoint"`
+	Call     int    `yaml:"call"`
+	Delay    string `yaml:"delay,omitempty"`
+	Status   int    `yaml:"status,omitempty"`
+	Drop     bool   `yaml:"drop,omitempty"`
 }
 
 // Output is the expected output given the inputs
@@ -33,3 +87,102 @@ type Output struct {
 	// Expect is the data expected to be sent
 	Expect UpdateWrapper `yaml:"expect"`
 }
+
+// Matcher tags match a field of an expectation by a rule instead of comparing it.
+const (
+	// MatchIgnore matches any value, or a missing field
+	MatchIgnore = "!ignore"
+	// MatchRegex matches a string by a regular expression, like !regex '^Bump'
+	MatchRegex = "!regex"
+	// MatchSemver matches a version by a constraint, like !semver '>=1.2, <2'
+	MatchSemver = "!semver"
+	// MatchContains matches a string containing a substring, a list containing the items,
+	// or a map containing the keys and values, like !contains [rails]
+	MatchContains = "!contains"
+)
+
+var matcherTags = []string{MatchIgnore, MatchRegex, MatchSemver, MatchContains}
+
+// Matcher is a field of an expectation written with a matcher tag, Value is what follows the tag.
+type Matcher struct {
+	Tag   string
+	Value any
+}
+
+func (m Matcher) MarshalYAML() (interface{}, error) {
+	if m.Value == nil {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: m.Tag}, nil
+	}
+	var node yaml.Node
+	if err := node.Encode(m.Value); err != nil {
+		return nil, err
+	}
+	node.Tag = m.Tag
+	return &node, nil
+}
+
+// UnmarshalYAML keeps the matchers in the data of an expectation, other tags are decoded as usual.
+func (w *UpdateWrapper) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("line %d: expected a mapping with data", node.Line)
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == "data" {
+			data, err := decodeMatchers(node.Content[i+1])
+			if err != nil {
+				return err
+			}
+			w.Data = data
+		}
+	}
+	return nil
+}
+
+func decodeMatchers(node *yaml.Node) (any, error) {
+	if node.Kind == yaml.AliasNode {
+		return decodeMatchers(node.Alias)
+	}
+	if strings.HasPrefix(node.Tag, "!") && !strings.HasPrefix(node.Tag, "!!") {
+		if !slices.Contains(matcherTags, node.Tag) {
+			return nil, fmt.Errorf("line %d: unknown matcher %s, expected one of %s", node.Line, node.Tag, strings.Join(matcherTags, ", "))
+		}
+		m := Matcher{Tag: node.Tag}
+		if node.Kind != yaml.ScalarNode || node.Value != "" {
+			untagged := *node
+			untagged.Tag = ""
+			value, err := decodeMatchers(&untagged)
+			if err != nil {
+				return nil, err
+			}
+			m.Value = value
+		}
+		return m, nil
+	}
+
+	switch node.Kind {
+	case yaml.MappingNode:
+		data := map[string]any{}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			value, err := decodeMatchers(node.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			data[node.Content[i].Value] = value
+		}
+		return data, nil
+	case yaml.SequenceNode:
+		data := make([]any, 0, len(node.Content))
+		for _, n := range node.Content {
+			value, err := decodeMatchers(n)
+			if err != nil {
+				return nil, err
+			}
+			data = append(data, value)
+		}
+		return data, nil
+	default:
+		var data any
+		err := node.Decode(&data)
+		return data, err
+	}
+}
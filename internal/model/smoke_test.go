@@ -0,0 +1,60 @@
+package model
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestUpdateWrapper_UnmarshalYAML(t *testing.T) {
+	input := `
+output:
+  - type: create_pull_request
+    expect:
+      data:
+        pr-title: !regex '^Bump rails'
+        pr-body: !ignore
+        dependencies:
+          - name: rails
+            version: !semver '>=7.1'
+        dependency-group: !contains {name: rails}
+        base-commit-sha: abc
+`
+	var smokeTest SmokeTest
+	if err := yaml.Unmarshal([]byte(input), &smokeTest); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]any{
+		"pr-title": Matcher{Tag: MatchRegex, Value: "^Bump rails"},
+		"pr-body":  Matcher{Tag: MatchIgnore},
+		"dependencies": []any{map[string]any{
+			"name":    "rails",
+			"version": Matcher{Tag: MatchSemver, Value: ">=7.1"},
+		}},
+		"dependency-group": Matcher{Tag: MatchContains, Value: map[string]any{"name": "rails"}},
+		"base-commit-sha":  "abc",
+	}
+	if actual := smokeTest.Output[0].Expect.Data; !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %#v, got %#v", expected, actual)
+	}
+
+	t.Run("round trip", func(t *testing.T) {
+		out, err := yaml.Marshal(smokeTest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range []string{"pr-body: !ignore", "pr-title: !regex ^Bump rails", "version: !semver '>=7.1'", "dependency-group: !contains"} {
+			if !strings.Contains(string(out), s) {
+				t.Errorf("expected %q in:\n%s", s, out)
+			}
+		}
+	})
+	t.Run("unknown matcher", func(t *testing.T) {
+		err := yaml.Unmarshal([]byte("output:\n  - type: x\n    expect:\n      data:\n        a: !glob '*'\n"), &smokeTest)
+		if err == nil || !strings.Contains(err.Error(), "unknown matcher !glob") {
+			t.Errorf("expected an unknown matcher error, got %v", err)
+		}
+	})
+}
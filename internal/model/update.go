@@ -1,9 +1,25 @@
 package model
 
+import "encoding/json"
+
 type UpdateWrapper struct {
 	Data any `json:"data" yaml:"data"`
 }
 
+// RawData is the data of an endpoint the CLI doesn't know yet, kept as decoded JSON
+// so it's still recorded and can be compared.
+type RawData struct {
+	Value any
+}
+
+func (r RawData) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.Value)
+}
+
+func (r RawData) MarshalYAML() (interface{}, error) {
+	return r.Value, nil
+}
+
 type UpdateDependencyList struct {
 	Dependencies    []Dependency `json:"dependencies" yaml:"dependencies"`
 	DependencyFiles []string     `json:"dependency_files" yaml:"dependency_files"`
@@ -15,10 +15,16 @@ import (
 	"os"
 	"reflect"
 	"runtime"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/dependabot/cli/internal/model"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"gopkg.in/yaml.v3"
 )
 
@@ -36,8 +42,21 @@ type API struct {
 	hasExpectations bool
 	port            int
 	writer          io.Writer
+	traceParent     trace.SpanContext
+	strict          bool
+	recordMetrics   bool
+	anyOrder        bool
+	subset          bool
+	received        []model.Output
+	forwarder       *Forwarder
+
+	mu     sync.Mutex
+	faults []model.APIFault
+	calls  map[string]int
 }
 
+var tracer = otel.Tracer("github.com/dependabot/cli/internal/server")
+
 // NewAPI creates a new API instance and starts the server
 func NewAPI(expected []model.Output, writer io.Writer) *API {
 	fakeAPIHost := "127.0.0.1"
@@ -97,16 +116,123 @@ func (a *API) Stop() {
 	cancel()
 }
 
-// Complete adds any remaining expectations to the error queue
+// SetTraceContext makes the span in ctx the parent of the spans for API calls,
+// unless the caller sent its own trace context.
+func (a *API) SetTraceContext(ctx context.Context) {
+	a.traceParent = trace.SpanContextFromContext(ctx)
+}
+
+// SetStrict makes the API answer endpoints it doesn't know with a 501 instead of recording them.
+func (a *API) SetStrict(strict bool) {
+	a.strict = strict
+}
+
+// SetRecordMetrics keeps the increment_metric and record_ecosystem_meta calls in Actual.Metrics,
+// apart from the outputs so they don't affect expectations.
+func (a *API) SetRecordMetrics(record bool) {
+	a.recordMetrics = record
+}
+
+// SetForwarder relays the events the API receives to the forwarder's endpoints.
+func (a *API) SetForwarder(f *Forwarder) {
+	a.forwarder = f
+}
+
+// SetExpectationModes matches each output against any unmet expectation instead of the next one when anyOrder is set,
+// and ignores the outputs that don't match an expectation when subset is set.
+func (a *API) SetExpectationModes(anyOrder, subset bool) {
+	a.anyOrder = anyOrder
+	a.subset = subset
+}
+
+// Complete adds any remaining expectations to the error queue, then the outputs that didn't match one
 func (a *API) Complete() {
+	if a.anyOrder || a.subset {
+		a.completeReceived()
+		return
+	}
 	for i := a.cursor; i < len(a.Expectations); i++ {
 		exp := &a.Expectations[i]
 		a.Errors = append(a.Errors, fmt.Errorf("expectation not met: %v\n%v", exp.Type, exp.Expect))
 	}
 }
 
+// completeReceived matches the outputs to the expectations now that every output was received, and reports
+// the expectations that weren't met, then the outputs that didn't meet one unless it's a subset.
+func (a *API) completeReceived() {
+	var assigned []int
+	if a.anyOrder {
+		assigned = matchAnyOrder(a.Expectations, a.received)
+	} else {
+		assigned = matchInOrder(a.Expectations, a.received)
+	}
+	used := make([]bool, len(a.received))
+	unmet := map[string]int{}
+	for i, j := range assigned {
+		if j >= 0 {
+			used[j] = true
+		} else {
+			unmet[a.Expectations[i].Type]++
+		}
+	}
+	unused := map[string][]int{}
+	for j, out := range a.received {
+		if !used[j] {
+			unused[out.Type] = append(unused[out.Type], j)
+		}
+	}
+	for i, j := range assigned {
+		if j >= 0 {
+			continue
+		}
+		exp := &a.Expectations[i]
+		outputs := unused[exp.Type]
+		if unmet[exp.Type] == 1 && len(outputs) == 1 {
+			// the output must have been meant to meet the expectation, so they're compared field by field
+			used[outputs[0]] = true
+			for _, err := range checkExpectation(exp, exp.Type, &a.received[outputs[0]].Expect) {
+				if mismatch, ok := err.(*MismatchError); ok {
+					mismatch.Index = i
+				}
+				a.Errors = append(a.Errors, err)
+			}
+			continue
+		}
+		reason := "no unmatched output of the type to compare it to"
+		if len(outputs) > 0 {
+			reason = fmt.Sprintf("%d unmatched outputs of the type could be compared to it", len(outputs))
+		}
+		a.Errors = append(a.Errors, fmt.Errorf("expectation not met: %v, %s\n%v", exp.Type, reason, exp.Expect))
+	}
+	if a.subset {
+		return
+	}
+	for j, out := range a.received {
+		if !used[j] {
+			a.Errors = append(a.Errors, fmt.Errorf("unexpected output: %v\n%v", out.Type, out.Expect))
+		}
+	}
+}
+
 // ServeHTTP handles requests to the server
 func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(r.URL.String(), "/")
+	kind := parts[len(parts)-1]
+
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		ctx = trace.ContextWithSpanContext(ctx, a.traceParent)
+	}
+	_, span := tracer.Start(ctx, "API "+kind, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+		attribute.String("http.request.method", r.Method),
+		attribute.String("url.path", r.URL.Path),
+	))
+	defer span.End()
+
+	if a.injectFault(w, r, kind) {
+		return
+	}
+
 	data, err := io.ReadAll(r.Body)
 	if err != nil {
 		err = fmt.Errorf("failed to read body: %w", err)
@@ -119,32 +245,37 @@ func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	parts := strings.Split(r.URL.String(), "/")
-	kind := parts[len(parts)-1]
-
 	actual, err := decodeWrapper(kind, data)
+	if _, ok := actual.Data.(model.RawData); ok && a.strict {
+		a.pushError(fmt.Errorf("unexpected output type: %s", kind))
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
 	if err != nil {
 		a.pushError(err)
 	}
 
 	a.outputRequestData(kind, actual)
+	if err == nil {
+		// before the binary files are replaced with hashes, like the output
+		a.forwarder.Send(kind, actual.Data)
+	}
 
-	if kind == "create_pull_request" && actual != nil {
+	if kind == "create_pull_request" {
 		createPR := actual.Data.(model.CreatePullRequest)
 		createPR.UpdatedDependencyFiles = replaceBinaryWithHash(createPR.UpdatedDependencyFiles)
-	} else if kind == "update_pull_request" && actual != nil {
+	} else if kind == "update_pull_request" {
 		updatePR := actual.Data.(model.UpdatePullRequest)
 		updatePR.UpdatedDependencyFiles = replaceBinaryWithHash(updatePR.UpdatedDependencyFiles)
 	}
 
-	if actual == nil {
-		// indicates the kind (endpoint) isn't implemented in decodeWrapper, so return a 501
-		w.WriteHeader(http.StatusNotImplemented)
-		return
-	}
-
 	if kind == "increment_metric" || kind == "record_ecosystem_meta" {
 		// These calls are noisy and changeable; skip recording them in output
+		if a.recordMetrics {
+			a.mu.Lock()
+			a.Actual.Metrics = append(a.Actual.Metrics, model.Output{Type: kind, Expect: *actual})
+			a.mu.Unlock()
+		}
 		return
 	}
 
@@ -161,6 +292,11 @@ func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *API) assertExpectation(kind string, actual *model.UpdateWrapper) {
+	if a.anyOrder || a.subset {
+		// matched when every output was received, so each can be matched to the best expectation
+		a.received = append(a.received, model.Output{Type: kind, Expect: *actual})
+		return
+	}
 	if len(a.Expectations) <= a.cursor {
 		err := fmt.Errorf("missing expectation")
 		a.pushError(err)
@@ -168,13 +304,87 @@ func (a *API) assertExpectation(kind string, actual *model.UpdateWrapper) {
 	}
 	expect := &a.Expectations[a.cursor]
 	a.cursor++
-	if kind != expect.Type {
-		err := fmt.Errorf("type was unexpected: expected %v got %v", expect.Type, kind)
+	for _, err := range checkExpectation(expect, kind, actual) {
+		if mismatch, ok := err.(*MismatchError); ok {
+			mismatch.Index = a.cursor - 1
+		}
 		a.pushError(err)
-		return
+	}
+}
+
+// matchInOrder returns the output that meets each expectation, or -1, where the outputs meet the expectations
+// in order but there may be other outputs between them. Taking the first output that meets each one is best.
+func matchInOrder(expectations, outputs []model.Output) []int {
+	assigned := slices.Repeat([]int{-1}, len(expectations))
+	i := 0
+	for j := range outputs {
+		if i < len(expectations) && MatchesExpectation(&expectations[i], &outputs[j]) {
+			assigned[i] = j
+			i++
+		}
+	}
+	return assigned
+}
+
+// matchAnyOrder returns the output that meets each expectation, or -1, meeting as many expectations as
+// possible. Taking the first output that meets each one isn't enough, since a loose expectation like
+// one with !ignore could take the output a stricter expectation needs.
+func matchAnyOrder(expectations, outputs []model.Output) []int {
+	candidates := make([][]int, len(expectations))
+	for i := range expectations {
+		for j := range outputs {
+			if MatchesExpectation(&expectations[i], &outputs[j]) {
+				candidates[i] = append(candidates[i], j)
+			}
+		}
+	}
+
+	// a maximum bipartite matching, each expectation takes an output or moves the one that has it to another
+	assigned := slices.Repeat([]int{-1}, len(expectations))
+	owner := slices.Repeat([]int{-1}, len(outputs))
+	var assign func(i int, seen []bool) bool
+	assign = func(i int, seen []bool) bool {
+		for _, j := range candidates[i] {
+			if seen[j] {
+				continue
+			}
+			seen[j] = true
+			if owner[j] < 0 || assign(owner[j], seen) {
+				owner[j] = i
+				assigned[i] = j
+				return true
+			}
+		}
+		return false
+	}
+	for i := range expectations {
+		assign(i, make([]bool, len(outputs)))
+	}
+	return assigned
+}
+
+// MatchesExpectation reports whether the output meets the expectation, matchers included.
+func MatchesExpectation(expect, actual *model.Output) bool {
+	return len(checkExpectation(expect, actual.Type, &actual.Expect)) == 0
+}
+
+// checkExpectation returns the ways the output doesn't match the expectation.
+func checkExpectation(expect *model.Output, kind string, actual *model.UpdateWrapper) []error {
+	if kind != expect.Type {
+		return []error{fmt.Errorf("type was unexpected: expected %v got %v", expect.Type, kind)}
+	}
+	// the matchers are checked first, then replaced with the actual values so they compare equal
+	actualData, err := genericData(actual.Data)
+	if err != nil {
+		panic(err)
+	}
+	expectData, matchErrs := applyMatchers(expect.Expect.Data, actualData, "$")
+	var errs []error
+	for _, err := range matchErrs {
+		errs = append(errs, fmt.Errorf("%s: %w", kind, err))
 	}
 	// need to use decodeWrapper to get the right type to match the actual type
-	data, err := json.Marshal(expect.Expect)
+	data, err := json.Marshal(model.UpdateWrapper{Data: expectData})
 	if err != nil {
 		panic(err)
 	}
@@ -183,8 +393,13 @@ func (a *API) assertExpectation(kind string, actual *model.UpdateWrapper) {
 		panic(err)
 	}
 	if err = compare(expected, actual); err != nil {
-		a.pushError(err)
+		expectedData, genericErr := genericData(expected.Data)
+		if genericErr != nil {
+			panic(genericErr)
+		}
+		errs = append(errs, &MismatchError{Type: kind, Fields: diffFields(expectedData, actualData, "$"), Err: err})
 	}
+	return errs
 }
 
 func (a *API) outputRequestData(kind string, data *model.UpdateWrapper) {
@@ -249,7 +464,12 @@ func decodeWrapper(kind string, data []byte) (actual *model.UpdateWrapper, err e
 	case "increment_metric":
 		actual.Data, err = decode[model.IncrementMetric](data)
 	default:
-		return nil, fmt.Errorf("unexpected output type: %s", kind)
+		// an endpoint added to dependabot-core after this CLI, kept as JSON so its data isn't lost
+		var wrapper struct {
+			Data any `json:"data"`
+		}
+		err = json.Unmarshal(data, &wrapper)
+		actual.Data = model.RawData{Value: wrapper.Data}
 	}
 	return actual, err
 }
@@ -307,6 +527,8 @@ func compare(expect, actual *model.UpdateWrapper) error {
 		return compareRecordUpdateJobUnknownError(v, actual.Data.(model.RecordUpdateJobUnknownError))
 	case []model.RecordEcosystemMeta:
 		return compareRecordEcosystemMeta(v, actual.Data.([]model.RecordEcosystemMeta))
+	case model.RawData:
+		return compareRawData(v, actual.Data.(model.RawData))
 	default:
 		return fmt.Errorf("unexpected type: %s", reflect.TypeOf(v))
 	}
@@ -390,3 +612,10 @@ func compareRecordEcosystemMeta(expect, actual []model.RecordEcosystemMeta) erro
 	}
 	return unexpectedBody("record_ecosystem_meta")
 }
+
+func compareRawData(expect, actual model.RawData) error {
+	if reflect.DeepEqual(expect, actual) {
+		return nil
+	}
+	return unexpectedBody("unknown endpoint")
+}
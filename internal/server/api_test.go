@@ -10,9 +10,12 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/dependabot/cli/internal/model"
+	"gopkg.in/yaml.v3"
 )
 
 func Test_decodeWrapper(t *testing.T) {
@@ -30,11 +33,82 @@ func TestAPI_ServeHTTP(t *testing.T) {
 		response := httptest.NewRecorder()
 
 		api := NewAPI(nil, nil)
+		api.SetStrict(true)
 		api.ServeHTTP(response, request)
 
 		if response.Code != http.StatusNotImplemented {
 			t.Errorf("expected status code %d, got %d", http.StatusNotImplemented, response.Code)
 		}
+		if len(api.Actual.Output) != 0 {
+			t.Errorf("expected nothing to be recorded, got %v", api.Actual.Output)
+		}
+	})
+	t.Run("records unknown endpoints as raw JSON", func(t *testing.T) {
+		body := `{"data": {"name": "new-endpoint", "count": 2}}`
+		request := httptest.NewRequest("POST", "/update_jobs/cli/record_something_new", bytes.NewBufferString(body))
+		response := httptest.NewRecorder()
+
+		var stdout bytes.Buffer
+		api := NewAPI(nil, &stdout)
+		defer api.Stop()
+		api.ServeHTTP(response, request)
+
+		if response.Code != http.StatusOK {
+			t.Errorf("expected status code %d, got %d", http.StatusOK, response.Code)
+		}
+		if len(api.Errors) > 0 {
+			t.Errorf("expected no errors, got %v", api.Errors)
+		}
+		if len(api.Actual.Output) != 1 || api.Actual.Output[0].Type != "record_something_new" {
+			t.Fatalf("expected the endpoint to be recorded with its kind, got %v", api.Actual.Output)
+		}
+		expected := model.RawData{Value: map[string]any{"name": "new-endpoint", "count": float64(2)}}
+		if !reflect.DeepEqual(api.Actual.Output[0].Expect.Data, expected) {
+			t.Errorf("expected the raw data to be recorded, got %v", api.Actual.Output[0].Expect.Data)
+		}
+		if stdout.String() != `{"data":{"count":2,"name":"new-endpoint"},"type":"record_something_new"}`+"\n" {
+			t.Errorf("expected the raw data to be written to stdout, got %s", stdout.String())
+		}
+	})
+}
+
+func TestAPI_compareRawData(t *testing.T) {
+	var smokeTest model.SmokeTest
+	err := yaml.Unmarshal([]byte(`
+output:
+  - type: record_something_new
+    expect:
+      data:
+        name: new-endpoint
+        count: 2
+`), &smokeTest)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("matching", func(t *testing.T) {
+		api := NewAPI(smokeTest.Output, nil)
+		defer api.Stop()
+		actual, err := decodeWrapper("record_something_new", []byte(`{"data": {"count": 2, "name": "new-endpoint"}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		api.assertExpectation("record_something_new", actual)
+		if len(api.Errors) > 0 {
+			t.Errorf("expected no errors, got %v", api.Errors)
+		}
+	})
+	t.Run("different", func(t *testing.T) {
+		api := NewAPI(smokeTest.Output, nil)
+		defer api.Stop()
+		actual, err := decodeWrapper("record_something_new", []byte(`{"data": {"count": 3, "name": "new-endpoint"}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		api.assertExpectation("record_something_new", actual)
+		if len(api.Errors) != 1 {
+			t.Errorf("expected the difference to be an error, got %v", api.Errors)
+		}
 	})
 }
 
@@ -205,3 +279,108 @@ func TestAPI_compareDependencySubmissionRequest(t *testing.T) {
 		}
 	})
 }
+
+func TestAPI_SetRecordMetrics(t *testing.T) {
+	for _, record := range []bool{false, true} {
+		api := NewAPI(nil, nil)
+		api.SetRecordMetrics(record)
+		request := httptest.NewRequest("POST", "/update_jobs/cli/increment_metric", bytes.NewBufferString(`{"data": {"metric": "updater.started", "tags": {"package_manager": "bundler"}}}`))
+		api.ServeHTTP(httptest.NewRecorder(), request)
+		api.Stop()
+
+		if len(api.Actual.Output) != 0 {
+			t.Errorf("expected metrics never to be outputs, got %v", api.Actual.Output)
+		}
+		if record && len(api.Actual.Metrics) != 1 {
+			t.Errorf("expected the metric to be recorded, got %v", api.Actual.Metrics)
+		}
+		if !record && len(api.Actual.Metrics) != 0 {
+			t.Errorf("expected the metric not to be recorded, got %v", api.Actual.Metrics)
+		}
+	}
+}
+
+func TestAPI_SetExpectationModes(t *testing.T) {
+	output := func(sha string) model.Output {
+		return model.Output{Type: "mark_as_processed", Expect: model.UpdateWrapper{Data: model.MarkAsProcessed{BaseCommitSha: sha}}}
+	}
+	tests := []struct {
+		name     string
+		anyOrder bool
+		subset   bool
+		expected []string
+		actual   []string
+		errors   []string
+	}{
+		{"in order", false, false, []string{"a", "b"}, []string{"a", "b"}, nil},
+		{"out of order", false, false, []string{"a", "b"}, []string{"b", "a"}, []string{"unexpected body for mark_as_processed", "unexpected body for mark_as_processed"}},
+		{"any order", true, false, []string{"a", "b"}, []string{"b", "a"}, nil},
+		{"any order, one unmatched", true, false, []string{"a", "b"}, []string{"b", "c"}, []string{"unexpected body for mark_as_processed"}},
+		{"any order, unmatched and unexpected", true, false, []string{"a", "b", "e"}, []string{"b", "c", "d"}, []string{
+			"expectation not met: mark_as_processed, 2 unmatched outputs of the type could be compared to it",
+			"expectation not met: mark_as_processed, 2 unmatched outputs of the type could be compared to it",
+			"unexpected output: mark_as_processed",
+			"unexpected output: mark_as_processed",
+		}},
+		{"any order, missing", true, false, []string{"a", "b"}, []string{"b"}, []string{"expectation not met: mark_as_processed, no unmatched output of the type to compare it to"}},
+		{"subset", false, true, []string{"a", "c"}, []string{"a", "b", "c", "d"}, nil},
+		{"subset out of order", false, true, []string{"c", "a"}, []string{"a", "b", "c"}, []string{"expectation not met: mark_as_processed, 2 unmatched outputs of the type could be compared to it"}},
+		{"any order subset", true, true, []string{"c", "a"}, []string{"a", "b", "c"}, nil},
+		{"any order subset, unmatched", true, true, []string{"c", "e"}, []string{"c", "d"}, []string{"unexpected body for mark_as_processed"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var expected []model.Output
+			for _, sha := range tt.expected {
+				expected = append(expected, output(sha))
+			}
+			api := NewAPI(expected, nil)
+			defer api.Stop()
+			api.SetExpectationModes(tt.anyOrder, tt.subset)
+			for _, sha := range tt.actual {
+				out := output(sha)
+				api.assertExpectation(out.Type, &out.Expect)
+			}
+			api.Complete()
+
+			var errs []string
+			for _, err := range api.Errors {
+				errs = append(errs, strings.SplitN(err.Error(), "\n", 2)[0])
+			}
+			if !reflect.DeepEqual(errs, tt.errors) {
+				t.Errorf("expected errors %q, got %q", tt.errors, errs)
+			}
+		})
+	}
+}
+
+func TestAPI_SetExpectationModes_matchers(t *testing.T) {
+	// the first expectation meets either output, but only the second output meets the second expectation
+	var smokeTest model.SmokeTest
+	err := yaml.Unmarshal([]byte(`
+output:
+  - type: mark_as_processed
+    expect:
+      data:
+        base-commit-sha: !ignore
+  - type: mark_as_processed
+    expect:
+      data:
+        base-commit-sha: b
+`), &smokeTest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	api := NewAPI(smokeTest.Output, nil)
+	defer api.Stop()
+	api.SetExpectationModes(true, false)
+	for _, sha := range []string{"b", "c"} {
+		out := model.Output{Type: "mark_as_processed", Expect: model.UpdateWrapper{Data: model.MarkAsProcessed{BaseCommitSha: sha}}}
+		api.assertExpectation(out.Type, &out.Expect)
+	}
+	api.Complete()
+
+	if len(api.Errors) != 0 {
+		t.Errorf("expected each output to meet an expectation, got %v", api.Errors)
+	}
+}
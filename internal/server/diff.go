@@ -0,0 +1,78 @@
+package server
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+)
+
+// FieldDiff is a field that's different in the output than in the expectation, a nil value is a missing field.
+type FieldDiff struct {
+	// Path is the JSON path of the field, like $.dependencies[0].version
+	Path     string
+	Expected any
+	Actual   any
+}
+
+// MismatchError is an output whose body doesn't match its expectation, with the fields that differ.
+type MismatchError struct {
+	// Index is the position of the expectation in the smoke test's output list
+	Index  int
+	Type   string
+	Fields []FieldDiff
+	// Err is the error from comparing them
+	Err error
+}
+
+func (e *MismatchError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *MismatchError) Unwrap() error {
+	return e.Err
+}
+
+// diffFields returns the fields that differ between the expected and actual data, as written in a smoke test.
+func diffFields(expected, actual any, path string) []FieldDiff {
+	switch e := expected.(type) {
+	case map[string]any:
+		a, ok := actual.(map[string]any)
+		if !ok {
+			break
+		}
+		var diffs []FieldDiff
+		keys := slices.Collect(maps.Keys(e))
+		for k := range a {
+			if _, found := e[k]; !found {
+				keys = append(keys, k)
+			}
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			diffs = append(diffs, diffFields(e[k], a[k], path+"."+k)...)
+		}
+		return diffs
+	case []any:
+		a, ok := actual.([]any)
+		if !ok {
+			break
+		}
+		var diffs []FieldDiff
+		for i := range max(len(e), len(a)) {
+			var ev, av any
+			if i < len(e) {
+				ev = e[i]
+			}
+			if i < len(a) {
+				av = a[i]
+			}
+			diffs = append(diffs, diffFields(ev, av, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+		return diffs
+	}
+	if reflect.DeepEqual(expected, actual) {
+		return nil
+	}
+	return []FieldDiff{{Path: path, Expected: expected, Actual: actual}}
+}
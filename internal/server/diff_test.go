@@ -0,0 +1,87 @@
+package server
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/dependabot/cli/internal/model"
+)
+
+func Test_diffFields(t *testing.T) {
+	expected := map[string]any{
+		"base-commit-sha": "abc",
+		"dependencies":    []any{map[string]any{"name": "rails", "version": "7.1.2"}},
+		"pr-title":        "Bump rails",
+	}
+	actual := map[string]any{
+		"base-commit-sha": "abc",
+		"dependencies":    []any{map[string]any{"name": "rails", "version": "7.1.3"}, map[string]any{"name": "rack"}},
+		"pr-body":         "Bumps rails.",
+	}
+	want := []FieldDiff{
+		{Path: "$.dependencies[0].version", Expected: "7.1.2", Actual: "7.1.3"},
+		{Path: "$.dependencies[1]", Expected: nil, Actual: map[string]any{"name": "rack"}},
+		{Path: "$.pr-body", Expected: nil, Actual: "Bumps rails."},
+		{Path: "$.pr-title", Expected: "Bump rails", Actual: nil},
+	}
+	if got := diffFields(expected, actual, "$"); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+	if got := diffFields(expected, expected, "$"); got != nil {
+		t.Errorf("expected no differences, got %v", got)
+	}
+}
+
+func TestAPI_assertExpectation_mismatch(t *testing.T) {
+	output := func(sha string) model.Output {
+		return model.Output{Type: "mark_as_processed", Expect: model.UpdateWrapper{Data: model.MarkAsProcessed{BaseCommitSha: sha}}}
+	}
+	api := NewAPI([]model.Output{output("a"), output("b")}, nil)
+	defer api.Stop()
+	for _, sha := range []string{"a", "c"} {
+		out := output(sha)
+		api.assertExpectation(out.Type, &out.Expect)
+	}
+
+	if len(api.Errors) != 1 {
+		t.Fatalf("expected one error, got %v", api.Errors)
+	}
+	var mismatch *MismatchError
+	if !errors.As(api.Errors[0], &mismatch) {
+		t.Fatalf("expected a mismatch, got %v", api.Errors[0])
+	}
+	want := []FieldDiff{{Path: "$.base-commit-sha", Expected: "b", Actual: "c"}}
+	if mismatch.Index != 1 || mismatch.Type != "mark_as_processed" || !reflect.DeepEqual(mismatch.Fields, want) {
+		t.Errorf("unexpected mismatch %+v", mismatch)
+	}
+	if mismatch.Error() != "unexpected body for mark_as_processed" {
+		t.Errorf("expected the compare error, got %q", mismatch.Error())
+	}
+}
+
+func TestAPI_Complete_mismatchAnyOrder(t *testing.T) {
+	output := func(sha string) model.Output {
+		return model.Output{Type: "mark_as_processed", Expect: model.UpdateWrapper{Data: model.MarkAsProcessed{BaseCommitSha: sha}}}
+	}
+	api := NewAPI([]model.Output{output("a"), output("b")}, nil)
+	defer api.Stop()
+	api.SetExpectationModes(true, false)
+	for _, sha := range []string{"c", "b"} {
+		out := output(sha)
+		api.assertExpectation(out.Type, &out.Expect)
+	}
+	api.Complete()
+
+	if len(api.Errors) != 1 {
+		t.Fatalf("expected one error, got %v", api.Errors)
+	}
+	var mismatch *MismatchError
+	if !errors.As(api.Errors[0], &mismatch) {
+		t.Fatalf("expected a mismatch, got %v", api.Errors[0])
+	}
+	want := []FieldDiff{{Path: "$.base-commit-sha", Expected: "a", Actual: "c"}}
+	if mismatch.Index != 0 || mismatch.Type != "mark_as_processed" || !reflect.DeepEqual(mismatch.Fields, want) {
+		t.Errorf("unexpected mismatch %+v", mismatch)
+	}
+}
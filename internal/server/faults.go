@@ -0,0 +1,121 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/dependabot/cli/internal/model"
+	"gopkg.in/yaml.v3"
+)
+
+// ReadFaults reads a fault profile, which is a YAML or JSON list of faults.
+func ReadFaults(path string) ([]model.APIFault, error) {
+	data, err := os.ReadFile(path) //nolint:gosec // file path is provided by the user via CLI flags
+	if err != nil {
+		return nil, fmt.Errorf("failed to open fault profile: %w", err)
+	}
+	var faults []model.APIFault
+	if err = json.Unmarshal(data, &faults); err != nil {
+		if err = yaml.Unmarshal(data, &faults); err != nil {
+			return nil, fmt.Errorf("failed to decode fault profile: %w", err)
+		}
+	}
+	if err = ValidateFaults(faults); err != nil {
+		return nil, err
+	}
+	return faults, nil
+}
+
+// ValidateFaults checks that each fault does something the API can inject.
+func ValidateFaults(faults []model.APIFault) error {
+	for i, f := range faults {
+		if f.Delay == "" && f.Status == 0 && !f.Drop {
+			return fmt.Errorf("API fault %d doesn't delay, fail, or drop the call", i+1)
+		}
+		if f.Delay != "" {
+			if d, err := time.ParseDuration(f.Delay); err != nil || d < 0 {
+				return fmt.Errorf("API fault %d has an invalid delay %q, expected a duration like 5s", i+1, f.Delay)
+			}
+		}
+		if f.Status != 0 && (f.Status < 400 || f.Status > 599) {
+			return fmt.Errorf("API fault %d has status %d, expected a 4xx or 5xx status", i+1, f.Status)
+		}
+		if f.Status != 0 && f.Drop {
+			return fmt.Errorf("API fault %d can't both respond with a status and drop the connection", i+1)
+		}
+		for _, call := range f.Calls {
+			if call < 1 {
+				return fmt.Errorf("API fault %d has call %d, calls are numbered from 1", i+1, call)
+			}
+		}
+	}
+	return nil
+}
+
+// SetFaults makes the API inject the faults into the calls they match.
+func (a *API) SetFaults(faults []model.APIFault) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.faults = faults
+	a.calls = map[string]int{}
+}
+
+// fault counts the call to the endpoint, and returns the fault to inject into it if there's one.
+// Failed calls are counted too, so the call number of a retry is the attempt's.
+func (a *API) fault(kind string) (*model.InjectedFault, time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.faults) == 0 {
+		return nil, 0
+	}
+	a.calls[kind]++
+	call := a.calls[kind]
+	for _, f := range a.faults {
+		if f.Endpoint != "" && f.Endpoint != kind {
+			continue
+		}
+		if len(f.Calls) > 0 && !slices.Contains(f.Calls, call) {
+			continue
+		}
+		injected := model.InjectedFault{Endpoint: kind, Call: call, Delay: f.Delay, Status: f.Status, Drop: f.Drop}
+		a.Actual.InjectedFaults = append(a.Actual.InjectedFaults, injected)
+		// validated by ValidateFaults
+		delay, _ := time.ParseDuration(f.Delay)
+		return &injected, delay
+	}
+	return nil, 0
+}
+
+// injectFault injects the fault matching the call, if any, and reports whether it answered the call.
+// A fault that only delays lets the call be handled afterward.
+func (a *API) injectFault(w http.ResponseWriter, r *http.Request, kind string) bool {
+	fault, delay := a.fault(kind)
+	if fault == nil {
+		return false
+	}
+	log.Printf("injecting a fault into %s call %d", kind, fault.Call)
+
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return true
+		}
+	}
+	switch {
+	case fault.Drop:
+		// the server closes the connection without writing a response
+		panic(http.ErrAbortHandler)
+	case fault.Status != 0:
+		_, _ = io.Copy(io.Discard, r.Body)
+		http.Error(w, fmt.Sprintf("fault injected by the Dependabot CLI: %d %s", fault.Status, http.StatusText(fault.Status)), fault.Status)
+		return true
+	}
+	return false
+}
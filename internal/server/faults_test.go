@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dependabot/cli/internal/model"
+)
+
+func TestValidateFaults(t *testing.T) {
+	tests := []struct {
+		name  string
+		fault model.APIFault
+		valid bool
+	}{
+		{"status", model.APIFault{Endpoint: "create_pull_request", Status: 500}, true},
+		{"delay", model.APIFault{Delay: "2s", Calls: []int{1, 3}}, true},
+		{"drop", model.APIFault{Drop: true}, true},
+		{"no failure", model.APIFault{Endpoint: "create_pull_request"}, false},
+		{"invalid delay", model.APIFault{Delay: "soon"}, false},
+		{"successful status", model.APIFault{Status: 200}, false},
+		{"status and drop", model.APIFault{Status: 500, Drop: true}, false},
+		{"call zero", model.APIFault{Status: 500, Calls: []int{0}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateFaults([]model.APIFault{tt.fault}); (err == nil) != tt.valid {
+				t.Errorf("expected valid to be %v, got error %v", tt.valid, err)
+			}
+		})
+	}
+}
+
+func TestReadFaults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "faults.yml")
+	profile := "- endpoint: create_pull_request\n  calls: [2]\n  status: 503\n- delay: 100ms\n"
+	if err := os.WriteFile(path, []byte(profile), 0600); err != nil {
+		t.Fatal(err)
+	}
+	faults, err := ReadFaults(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(faults) != 2 || faults[0].Status != 503 || faults[0].Calls[0] != 2 || faults[1].Delay != "100ms" {
+		t.Errorf("unexpected faults %+v", faults)
+	}
+}
+
+func TestAPI_injectFault(t *testing.T) {
+	api := NewAPI(nil, nil)
+	defer api.Stop()
+	api.SetFaults([]model.APIFault{
+		{Endpoint: "create_pull_request", Calls: []int{1}, Status: 503},
+		{Endpoint: "mark_as_processed", Drop: true},
+		{Endpoint: "update_dependency_list", Delay: "50ms"},
+	})
+
+	post := func(kind, body string) (*http.Response, error) {
+		url := fmt.Sprintf("http://127.0.0.1:%d/update_jobs/cli/%s", api.Port(), kind)
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, url, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return http.DefaultClient.Do(req)
+	}
+	createPR := `{"data": {"base-commit-sha": "abc", "dependencies": [], "updated-dependency-files": []}}`
+
+	t.Run("status on the first call only", func(t *testing.T) {
+		resp, err := post("create_pull_request", createPR)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("expected the first call to fail with 503, got %d", resp.StatusCode)
+		}
+		if resp, err = post("create_pull_request", createPR); err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("expected the retry to succeed, got %d", resp.StatusCode)
+		}
+		if len(api.Actual.Output) != 1 {
+			t.Errorf("expected only the retry to be recorded, got %d outputs", len(api.Actual.Output))
+		}
+	})
+	t.Run("dropped connection", func(t *testing.T) {
+		resp, err := post("mark_as_processed", `{"data": {"base-commit-sha": "abc"}}`)
+		if err == nil {
+			resp.Body.Close()
+			t.Errorf("expected the connection to be dropped, got %d", resp.StatusCode)
+		}
+	})
+	t.Run("delay", func(t *testing.T) {
+		start := time.Now()
+		resp, err := post("update_dependency_list", `{"data": {"dependencies": [], "dependency_files": []}}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+			t.Errorf("expected the response to be delayed, took %s", elapsed)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("expected the delayed call to be handled, got %d", resp.StatusCode)
+		}
+	})
+
+	expected := []model.InjectedFault{
+		{Endpoint: "create_pull_request", Call: 1, Status: 503},
+		{Endpoint: "mark_as_processed", Call: 1, Drop: true},
+		{Endpoint: "update_dependency_list", Call: 1, Delay: "50ms"},
+	}
+	if fmt.Sprint(api.Actual.InjectedFaults) != fmt.Sprint(expected) {
+		t.Errorf("expected the injected faults to be recorded, got %+v", api.Actual.InjectedFaults)
+	}
+}
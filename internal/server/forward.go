@@ -0,0 +1,237 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// SignatureHeader carries the HMAC-SHA256 of a forwarded body, like GitHub's webhooks.
+const SignatureHeader = "X-Dependabot-Signature-256"
+
+// ForwardedEvent is an API call relayed to the forwarding endpoints.
+type ForwardedEvent struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Forwarder relays the API's events to HTTP endpoints in the background, in the order they were received.
+// A nil *Forwarder is valid and forwards nothing.
+type Forwarder struct {
+	urls      []string
+	secret    []byte
+	batchSize int
+	client    *http.Client
+	attempts  int
+	backoff   time.Duration
+	timeout   time.Duration
+
+	mu      sync.Mutex
+	pending []ForwardedEvent
+	batches [][]ForwardedEvent
+	closed  bool
+	wake    chan struct{}
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+	once    sync.Once
+	failed  int
+}
+
+// NewForwarder starts forwarding to the URLs. Bodies are signed when there's a secret, and with a batch size
+// over 1 the events are sent as JSON arrays of up to that many events.
+func NewForwarder(urls []string, secret string, batchSize int) *Forwarder {
+	ctx, cancel := context.WithCancel(context.Background())
+	f := &Forwarder{
+		urls:      urls,
+		secret:    []byte(secret),
+		batchSize: max(batchSize, 1),
+		client:    &http.Client{Timeout: 10 * time.Second},
+		attempts:  5,
+		backoff:   500 * time.Millisecond,
+		timeout:   30 * time.Second,
+		wake:      make(chan struct{}, 1),
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+	go f.run()
+	return f
+}
+
+// Send queues an event. The data is encoded right away, since the API changes some of it afterward.
+// It never blocks, so a slow endpoint can't hold up the updater's API calls.
+func (f *Forwarder) Send(kind string, data any) {
+	if f == nil {
+		return
+	}
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("failed to forward %s: %v", kind, err)
+		return
+	}
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return
+	}
+	f.pending = append(f.pending, ForwardedEvent{Type: kind, Data: encoded})
+	if len(f.pending) >= f.batchSize {
+		f.batches = append(f.batches, f.pending)
+		f.pending = nil
+	}
+	f.mu.Unlock()
+	f.signal()
+}
+
+// signal wakes the worker up if it's waiting for a batch.
+func (f *Forwarder) signal() {
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Close sends the events that are still pending and waits for them to be delivered, or gives up after a while.
+func (f *Forwarder) Close() {
+	if f == nil {
+		return
+	}
+	f.once.Do(func() {
+		f.mu.Lock()
+		if len(f.pending) > 0 {
+			f.batches = append(f.batches, f.pending)
+			f.pending = nil
+		}
+		f.closed = true
+		f.mu.Unlock()
+		f.signal()
+
+		select {
+		case <-f.done:
+		case <-time.After(f.timeout):
+			log.Println("gave up waiting for events to be forwarded")
+			f.cancel()
+			<-f.done
+		}
+		f.cancel()
+		for _, batch := range f.batches {
+			f.failed += len(batch)
+		}
+		if f.failed > 0 {
+			log.Printf("failed to forward %d events", f.failed)
+		}
+	})
+}
+
+// next waits for the next batch, it returns false once the forwarder is closed and every batch was taken.
+func (f *Forwarder) next() ([]ForwardedEvent, bool) {
+	for {
+		// once Close gives up, the batches left are counted as failed rather than sent
+		if f.ctx.Err() != nil {
+			return nil, false
+		}
+		f.mu.Lock()
+		if len(f.batches) > 0 {
+			batch := f.batches[0]
+			f.batches = f.batches[1:]
+			f.mu.Unlock()
+			return batch, true
+		}
+		closed := f.closed
+		f.mu.Unlock()
+		if closed {
+			return nil, false
+		}
+		select {
+		case <-f.wake:
+		case <-f.ctx.Done():
+			return nil, false
+		}
+	}
+}
+
+func (f *Forwarder) run() {
+	defer close(f.done)
+	for {
+		batch, ok := f.next()
+		if !ok {
+			return
+		}
+		var body []byte
+		var err error
+		if f.batchSize > 1 {
+			body, err = json.Marshal(batch)
+		} else {
+			body, err = json.Marshal(batch[0])
+		}
+		if err != nil {
+			log.Println("failed to encode forwarded events:", err)
+			continue
+		}
+		for _, url := range f.urls {
+			if err := f.deliver(url, batch[0].Type, body); err != nil {
+				log.Printf("failed to forward events to %s: %v", url, err)
+				f.failed += len(batch)
+			}
+		}
+	}
+}
+
+// deliver posts the body, retrying with exponential backoff when the endpoint can't be reached or has a server error.
+func (f *Forwarder) deliver(url, kind string, body []byte) error {
+	backoff := f.backoff
+	var err error
+	for attempt := 1; attempt <= f.attempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-time.After(backoff):
+			case <-f.ctx.Done():
+				return f.ctx.Err()
+			}
+			backoff *= 2
+		}
+		var retry bool
+		if retry, err = f.post(url, kind, body); err == nil || !retry {
+			return err
+		}
+	}
+	return err
+}
+
+func (f *Forwarder) post(url, kind string, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(f.ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if f.batchSize == 1 {
+		req.Header.Set("X-Dependabot-Event", kind)
+	}
+	if len(f.secret) > 0 {
+		mac := hmac.New(sha256.New, f.secret)
+		mac.Write(body)
+		req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		retry = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return retry, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return false, nil
+}
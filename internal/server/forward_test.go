@@ -0,0 +1,169 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dependabot/cli/internal/model"
+)
+
+type forwardReceiver struct {
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+	// failures is how many requests fail with a 503 before they succeed
+	failures int
+}
+
+func (f *forwardReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failures > 0 {
+		f.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	f.requests = append(f.requests, r)
+	f.bodies = append(f.bodies, body)
+}
+
+func TestForwarder(t *testing.T) {
+	t.Run("signed events with retries", func(t *testing.T) {
+		receiver := &forwardReceiver{failures: 2}
+		server := httptest.NewServer(receiver)
+		defer server.Close()
+
+		f := NewForwarder([]string{server.URL}, "secret", 0)
+		f.backoff = time.Millisecond
+		f.Send("mark_as_processed", model.MarkAsProcessed{BaseCommitSha: "abc"})
+		f.Close()
+
+		if len(receiver.bodies) != 1 {
+			t.Fatalf("expected the event to be delivered once, got %d", len(receiver.bodies))
+		}
+		var event ForwardedEvent
+		if err := json.Unmarshal(receiver.bodies[0], &event); err != nil {
+			t.Fatal(err)
+		}
+		if event.Type != "mark_as_processed" || string(event.Data) != `{"base-commit-sha":"abc"}` {
+			t.Errorf("unexpected event %s", receiver.bodies[0])
+		}
+		if kind := receiver.requests[0].Header.Get("X-Dependabot-Event"); kind != "mark_as_processed" {
+			t.Errorf("expected the event header, got %q", kind)
+		}
+		mac := hmac.New(sha256.New, []byte("secret"))
+		mac.Write(receiver.bodies[0])
+		if signature := receiver.requests[0].Header.Get(SignatureHeader); signature != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+			t.Errorf("unexpected signature %q", signature)
+		}
+	})
+	t.Run("batches", func(t *testing.T) {
+		receiver := &forwardReceiver{}
+		server := httptest.NewServer(receiver)
+		defer server.Close()
+
+		f := NewForwarder([]string{server.URL, server.URL}, "", 2)
+		for range 3 {
+			f.Send("increment_metric", model.IncrementMetric{Metric: "updater.started"})
+		}
+		f.Close()
+
+		// two endpoints receive a full batch and then the rest
+		if len(receiver.bodies) != 4 {
+			t.Fatalf("expected 4 deliveries, got %d", len(receiver.bodies))
+		}
+		var sizes []int
+		for _, body := range receiver.bodies {
+			var events []ForwardedEvent
+			if err := json.Unmarshal(body, &events); err != nil {
+				t.Fatal(err)
+			}
+			sizes = append(sizes, len(events))
+		}
+		if !bytes.Equal(receiver.bodies[0], receiver.bodies[1]) || sizes[0] != 2 || sizes[2] != 1 {
+			t.Errorf("unexpected batch sizes %v", sizes)
+		}
+		if signature := receiver.requests[0].Header.Get(SignatureHeader); signature != "" {
+			t.Errorf("expected no signature without a secret, got %q", signature)
+		}
+	})
+	t.Run("client errors aren't retried", func(t *testing.T) {
+		var requests int
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			requests++
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+		defer server.Close()
+
+		f := NewForwarder([]string{server.URL}, "", 0)
+		f.backoff = time.Millisecond
+		f.Send("mark_as_processed", model.MarkAsProcessed{})
+		f.Close()
+
+		if requests != 1 || f.failed != 1 {
+			t.Errorf("expected a single attempt that failed, got %d attempts and %d failures", requests, f.failed)
+		}
+	})
+	t.Run("a hung endpoint doesn't block", func(t *testing.T) {
+		release := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		}))
+		defer server.Close()
+		defer close(release)
+
+		f := NewForwarder([]string{server.URL}, "", 0)
+		f.timeout = 50 * time.Millisecond
+		start := time.Now()
+		for range 500 {
+			f.Send("mark_as_processed", model.MarkAsProcessed{})
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("expected sending to return right away, took %s", elapsed)
+		}
+		f.Close()
+
+		if f.failed != 500 {
+			t.Errorf("expected every event to fail, got %d failures", f.failed)
+		}
+	})
+}
+
+func TestAPI_SetForwarder(t *testing.T) {
+	receiver := &forwardReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	api := NewAPI(nil, nil)
+	defer api.Stop()
+	f := NewForwarder([]string{server.URL}, "", 0)
+	api.SetForwarder(f)
+
+	request := httptest.NewRequest("POST", "/update_jobs/cli/record_update_job_error", bytes.NewBufferString(`{"data": {"error-type": "unknown_error", "error-details": {}}}`))
+	api.ServeHTTP(httptest.NewRecorder(), request)
+	f.Close()
+
+	if len(receiver.bodies) != 1 {
+		t.Fatalf("expected the call to be forwarded, got %d", len(receiver.bodies))
+	}
+	var event ForwardedEvent
+	if err := json.Unmarshal(receiver.bodies[0], &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != "record_update_job_error" {
+		t.Errorf("expected the kind to be forwarded, got %q", event.Type)
+	}
+}
@@ -0,0 +1,314 @@
+package server
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/dependabot/cli/internal/model"
+	"gopkg.in/yaml.v3"
+)
+
+// genericData returns the data as it's written in a smoke test, with maps keyed by the YAML names.
+func genericData(data any) (any, error) {
+	out, err := yaml.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var generic any
+	err = yaml.Unmarshal(out, &generic)
+	return generic, err
+}
+
+// applyMatchers checks the matchers in the expected data against the actual data, and returns the expected data
+// with each matcher replaced by the actual value so the rest of it can be compared as usual. A field with a
+// matcher that's missing from the actual data is removed. The expected data isn't modified.
+func applyMatchers(expected, actual any, path string) (any, []error) {
+	switch e := expected.(type) {
+	case model.Matcher:
+		if err := match(e, actual); err != nil {
+			return actual, []error{fmt.Errorf("%s %w", path, err)}
+		}
+		return actual, nil
+	case map[string]any:
+		a, ok := actual.(map[string]any)
+		if !ok {
+			return stripMatchers(e), nil
+		}
+		var errs []error
+		result := make(map[string]any, len(e))
+		// in order, so the errors are too
+		for _, k := range slices.Sorted(maps.Keys(e)) {
+			v := e[k]
+			av, found := a[k]
+			if m, isMatcher := v.(model.Matcher); isMatcher && !found {
+				if m.Tag != model.MatchIgnore {
+					errs = append(errs, fmt.Errorf("%s.%s is missing, expected %s", path, k, describeMatcher(m)))
+				}
+				continue
+			}
+			value, valueErrs := applyMatchers(v, av, path+"."+k)
+			result[k] = value
+			errs = append(errs, valueErrs...)
+		}
+		return result, errs
+	case []any:
+		a, ok := actual.([]any)
+		if !ok {
+			return stripMatchers(e), nil
+		}
+		var errs []error
+		result := make([]any, len(e))
+		for i, v := range e {
+			if i >= len(a) {
+				result[i] = stripMatchers(v)
+				continue
+			}
+			var itemErrs []error
+			result[i], itemErrs = applyMatchers(v, a[i], fmt.Sprintf("%s[%d]", path, i))
+			errs = append(errs, itemErrs...)
+		}
+		return result, errs
+	default:
+		return expected, nil
+	}
+}
+
+// stripMatchers removes the matchers where the actual data doesn't have the same shape,
+// the difference is reported when the rest is compared.
+func stripMatchers(expected any) any {
+	switch e := expected.(type) {
+	case model.Matcher:
+		return nil
+	case map[string]any:
+		result := make(map[string]any, len(e))
+		for k, v := range e {
+			if _, ok := v.(model.Matcher); !ok {
+				result[k] = stripMatchers(v)
+			}
+		}
+		return result
+	case []any:
+		result := make([]any, len(e))
+		for i, v := range e {
+			result[i] = stripMatchers(v)
+		}
+		return result
+	default:
+		return expected
+	}
+}
+
+func describeMatcher(m model.Matcher) string {
+	if m.Value == nil {
+		return m.Tag
+	}
+	return fmt.Sprintf("%s %v", m.Tag, m.Value)
+}
+
+// match returns an error describing how the actual value doesn't match.
+func match(m model.Matcher, actual any) error {
+	switch m.Tag {
+	case model.MatchIgnore:
+		return nil
+	case model.MatchRegex:
+		pattern, ok := m.Value.(string)
+		if !ok {
+			return fmt.Errorf("has an invalid %s, expected a pattern", m.Tag)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("has an invalid %s: %w", m.Tag, err)
+		}
+		s, ok := scalarString(actual)
+		if !ok || !re.MatchString(s) {
+			return fmt.Errorf("is %s, expected %s", formatValue(actual), describeMatcher(m))
+		}
+		return nil
+	case model.MatchSemver:
+		constraint, ok := m.Value.(string)
+		if !ok {
+			return fmt.Errorf("has an invalid %s, expected a constraint", m.Tag)
+		}
+		s, _ := scalarString(actual)
+		matched, err := semverMatches(constraint, s)
+		if err != nil {
+			return fmt.Errorf("has an invalid %s: %w", m.Tag, err)
+		}
+		if !matched {
+			return fmt.Errorf("is %s, expected %s", formatValue(actual), describeMatcher(m))
+		}
+		return nil
+	case model.MatchContains:
+		if !contains(actual, m.Value) {
+			return fmt.Errorf("is %s, expected %s", formatValue(actual), describeMatcher(m))
+		}
+		return nil
+	default:
+		return fmt.Errorf("has an unknown matcher %s", m.Tag)
+	}
+}
+
+// contains reports whether a string has the substring, a list has each item, or a map has each key and value.
+// The items and values may be matchers themselves.
+func contains(actual, expected any) bool {
+	switch a := actual.(type) {
+	case string:
+		s, ok := scalarString(expected)
+		return ok && strings.Contains(a, s)
+	case []any:
+		items, ok := expected.([]any)
+		if !ok {
+			items = []any{expected}
+		}
+		for _, item := range items {
+			found := false
+			for _, v := range a {
+				if matches(item, v) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	case map[string]any:
+		fields, ok := expected.(map[string]any)
+		if !ok {
+			return false
+		}
+		for k, v := range fields {
+			if av, found := a[k]; !found || !matches(v, av) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// matches reports whether the actual value is equal to the expected one, after applying any matchers in it.
+func matches(expected, actual any) bool {
+	applied, errs := applyMatchers(expected, actual, "")
+	return len(errs) == 0 && reflect.DeepEqual(applied, actual)
+}
+
+func scalarString(v any) (string, bool) {
+	switch v.(type) {
+	case string, int, int64, uint64, float64, bool:
+		return fmt.Sprint(v), true
+	default:
+		return "", false
+	}
+}
+
+func formatValue(v any) string {
+	if s, ok := v.(string); ok {
+		return strconv.Quote(s)
+	}
+	if v == nil {
+		return "missing"
+	}
+	return fmt.Sprint(v)
+}
+
+// semverMatches reports whether the version meets each of the comma-separated comparisons in the constraint,
+// like ">=1.2, <2". A leading "v" and build metadata are ignored, and a pre-release is before its release.
+func semverMatches(constraint, version string) (bool, error) {
+	v, ok := parseSemver(version)
+	if !ok {
+		return false, nil
+	}
+	for _, part := range strings.Split(constraint, ",") {
+		part = strings.TrimSpace(part)
+		var op string
+		for _, o := range []string{">=", "<=", "!=", "==", ">", "<", "="} {
+			if strings.HasPrefix(part, o) {
+				op = o
+				break
+			}
+		}
+		target, ok := parseSemver(strings.TrimSpace(strings.TrimPrefix(part, op)))
+		if !ok {
+			return false, fmt.Errorf("invalid version in %q", part)
+		}
+		cmp := compareSemver(v, target)
+		var met bool
+		switch op {
+		case "", "=", "==":
+			met = cmp == 0
+		case "!=":
+			met = cmp != 0
+		case ">":
+			met = cmp > 0
+		case ">=":
+			met = cmp >= 0
+		case "<":
+			met = cmp < 0
+		case "<=":
+			met = cmp <= 0
+		}
+		if !met {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+type semver struct {
+	parts      []int
+	prerelease string
+}
+
+func parseSemver(s string) (semver, bool) {
+	s = strings.TrimPrefix(s, "v")
+	s, _, _ = strings.Cut(s, "+")
+	s, pre, _ := strings.Cut(s, "-")
+	if s == "" {
+		return semver{}, false
+	}
+	v := semver{prerelease: pre}
+	for _, p := range strings.Split(s, ".") {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return semver{}, false
+		}
+		v.parts = append(v.parts, n)
+	}
+	return v, true
+}
+
+func compareSemver(a, b semver) int {
+	for i := 0; i < max(len(a.parts), len(b.parts)); i++ {
+		var x, y int
+		if i < len(a.parts) {
+			x = a.parts[i]
+		}
+		if i < len(b.parts) {
+			y = b.parts[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	switch {
+	case a.prerelease == b.prerelease:
+		return 0
+	case a.prerelease == "":
+		return 1
+	case b.prerelease == "":
+		return -1
+	default:
+		return strings.Compare(a.prerelease, b.prerelease)
+	}
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dependabot/cli/internal/model"
+	"gopkg.in/yaml.v3"
+)
+
+func Test_semverMatches(t *testing.T) {
+	tests := []struct {
+		constraint string
+		version    string
+		matches    bool
+	}{
+		{">=1.2", "1.2.0", true},
+		{">=1.2", "v1.10.3", true},
+		{">=1.2", "1.1.9", false},
+		{">=1.2, <2", "2.0.0", false},
+		{"<2", "2.0.0-rc.1", true},
+		{"1.2.3", "1.2.3+build.5", true},
+		{"!=1.2.3", "1.2.3", false},
+		{">=1", "not-a-version", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.constraint+" "+tt.version, func(t *testing.T) {
+			matched, err := semverMatches(tt.constraint, tt.version)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if matched != tt.matches {
+				t.Errorf("expected %v, got %v", tt.matches, matched)
+			}
+		})
+	}
+
+	if _, err := semverMatches("~>1.2", "1.2.0"); err == nil {
+		t.Error("expected an unknown operator to be invalid")
+	}
+}
+
+func TestAPI_assertExpectation_matchers(t *testing.T) {
+	expectations := func(t *testing.T, data string) []model.Output {
+		var smokeTest model.SmokeTest
+		if err := yaml.Unmarshal([]byte("output:\n  - type: create_pull_request\n    expect:\n      data:\n"+data), &smokeTest); err != nil {
+			t.Fatal(err)
+		}
+		return smokeTest.Output
+	}
+	actual, err := decodeWrapper("create_pull_request", []byte(`{"data": {
+		"base-commit-sha": "abc",
+		"dependencies": [{"name": "rails", "version": "7.1.2", "previous-version": "7.0.8", "requirements": []}],
+		"updated-dependency-files": [],
+		"pr-title": "Bump rails from 7.0.8 to 7.1.2",
+		"pr-body": "Bumps rails. See the [changelog](https://example.com/changelog).",
+		"dependency-group": {"name": "rails", "applies-to": "version-updates"}
+	}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		data   string
+		errors []string
+	}{
+		{
+			name: "matching",
+			data: `
+        base-commit-sha: abc
+        dependencies:
+          - name: rails
+            version: !semver '>=7.1, <8'
+            previous-version: !regex '^7\.'
+            requirements: []
+        updated-dependency-files: []
+        pr-title: !contains 'to 7.1.2'
+        pr-body: !ignore
+        commit-message: !ignore
+        dependency-group: !contains {name: rails}
+`,
+		},
+		{
+			name: "not matching",
+			data: `
+        base-commit-sha: abc
+        dependencies:
+          - name: rails
+            version: !semver '>=8'
+            previous-version: 7.0.8
+            requirements: []
+        updated-dependency-files: []
+        pr-title: !regex '^Update'
+        pr-body: !ignore
+        dependency-group: !contains {name: rails}
+`,
+			errors: []string{
+				`create_pull_request: $.dependencies[0].version is "7.1.2", expected !semver >=8`,
+				`create_pull_request: $.pr-title is "Bump rails from 7.0.8 to 7.1.2", expected !regex ^Update`,
+			},
+		},
+		{
+			name: "matched fields, other fields differ",
+			data: `
+        base-commit-sha: def
+        dependencies: !ignore
+        updated-dependency-files: []
+        pr-title: !ignore
+        pr-body: !ignore
+        dependency-group: !ignore
+`,
+			errors: []string{"unexpected body for create_pull_request"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := NewAPI(expectations(t, tt.data), nil)
+			defer api.Stop()
+			api.assertExpectation("create_pull_request", actual)
+
+			var errs []string
+			for _, err := range api.Errors {
+				errs = append(errs, err.Error())
+			}
+			if strings.Join(errs, "\n") != strings.Join(tt.errors, "\n") {
+				t.Errorf("expected errors:\n%s\ngot:\n%s", strings.Join(tt.errors, "\n"), strings.Join(errs, "\n"))
+			}
+		})
+	}
+}